@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+)
+
+// defaultConfigPath is where the config file is read from when -config is not given
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "rar-hunter", "config.toml")
+}
+
+// loadConfig sets the flags to the values in the TOML config file at path. The keys are the flag names, and arrays
+// set flags that can be given multiple times once per value. A missing file is only an error when path was given
+// explicitly
+func loadConfig(flags *flag.FlagSet, path string) error {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	if path == "" {
+		return nil
+	}
+
+	values := map[string]any{}
+	if _, err := toml.DecodeFile(path, &values); err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if flags.Lookup(key) == nil || key == "config" {
+			return fmt.Errorf("%s: unknown option %q", path, key)
+		}
+
+		list, ok := values[key].([]any)
+		if !ok {
+			list = []any{values[key]}
+		}
+		for _, v := range list {
+			if err := flags.Set(key, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("%s: invalid value for %s: %w", path, key, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/burmudar/rar-hunter/rary/event"
+)
+
+// openLog returns a logger that appends a record per action to the file at path, or one that discards everything
+// when path is empty
+func openLog(path string) (*slog.Logger, io.Closer, error) {
+	if path == "" {
+		return slog.New(slog.NewTextHandler(io.Discard, nil)), io.NopCloser(nil), nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return slog.New(slog.NewTextHandler(f, nil)), f, nil
+}
+
+// logEvent records the events published during extraction that are not otherwise visible in the results
+func logEvent(log *slog.Logger, ev any) {
+	switch e := ev.(type) {
+	case event.VolumesDeleted:
+		log.Info("deleted", "dir", e.WorkDir, "archive", e.Archive, "files", e.Files)
+	case event.DirLocked:
+		log.Info("skipped", "dir", e.WorkDir, "archive", e.Archive, "reason", "locked by another process")
+	case event.Quarantined:
+		log.Warn("quarantined", "dir", e.WorkDir, "archive", e.Archive, "to", e.Dir, "files", e.Files)
+	}
+}
@@ -1,59 +1,651 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io/fs"
+	"io"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	rary "github.com/burmudar/rar-hunter/rary"
+	"github.com/burmudar/rar-hunter/rary/event"
+	"github.com/burmudar/rar-hunter/rary/scanner"
+	"github.com/burmudar/rar-hunter/rary/sink"
 )
 
-func allDirs(start string) []string {
-	dirs := []string{start}
-	filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
-		if start == path {
-			return nil
+var errUsage = errors.New("usage")
+
+// stringList is a flag that can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+type options struct {
+	configFile string
+	dirFlags   stringList
+	dirs       []string
+	stdin      bool
+	format     string
+	// decisions collects the decisions of every level of a run with -format json, which are written once it is done
+	decisions   []rary.Decision
+	verbose     bool
+	dryRun      bool
+	concurrency int
+	scanWorkers int
+	unrarBin    string
+	watch       bool
+	debounce    time.Duration
+	settle      time.Duration
+	exclude     stringList
+	maxDepth    int
+	followLinks bool
+	includeExts stringList
+	excludeExts stringList
+	relative    bool
+	recursive   bool
+	maxLevels   int
+	formatList  string
+	formats     []string
+	minSize     int64
+	logFile     string
+	log         *slog.Logger
+	stateFile   string
+	force       bool
+	state       *state
+	quarantine  string
+	warnings    stringList
+	newer       time.Time
+	stream      bool
+	failFast    bool
+	events      string
+	sink        *sink.Sink
+}
+
+// newFlagSet defines every flag on a new FlagSet writing to opts
+func newFlagSet(name string, opts *options) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s [flags] [-dir <dir>] [dir...]\n", name)
+		fmt.Fprintf(flags.Output(), "a dir of - reads the directories to check from stdin, one per line, instead of scanning\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.configFile, "config", "", "TOML file with default values for the flags, defaults to "+defaultConfigPath())
+	flags.Var(&opts.dirFlags, "dir", "directory to search for archive sets, can be given multiple times")
+	flags.StringVar(&opts.format, "format", "text", "output format, either text or json. With -watch json is written as a line per decision")
+	flags.BoolVar(&opts.verbose, "verbose", false, "print why directories are skipped")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "print the unrar commands without running them")
+	flags.BoolVar(&opts.stream, "stream", false, "print the output of unrar as it is produced, prefixed with the archive name")
+	flags.IntVar(&opts.concurrency, "concurrency", 0, "maximum number of concurrent extractions, defaults to the number of CPUs")
+	flags.BoolVar(&opts.failFast, "fail-fast", false, "stop at the first set that fails to extract instead of extracting the rest")
+	flags.IntVar(&opts.scanWorkers, "scan-concurrency", 1, "number of directories checked concurrently while scanning")
+	flags.StringVar(&opts.unrarBin, "unrar-bin", "", "name or path of the unrar binary")
+
+	flags.StringVar(&opts.logFile, "log-file", "", "append a line for every directory scanned, skipped and extracted to this file")
+	flags.StringVar(&opts.events, "events", "", "send every event as a line of JSON to this unix socket path or POST it to this http URL")
+
+	flags.StringVar(&opts.stateFile, "state", "", "file remembering the directories that were resolved so that later runs skip them until they change")
+	flags.BoolVar(&opts.force, "force", false, "check every directory again, ignoring the -state file")
+
+	flags.StringVar(&opts.quarantine, "quarantine-dir", "", "move the volumes of sets that fail with a CRC or integrity error into this directory")
+
+	flags.Var(&opts.warnings, "warning-pattern", "regular expression for unrar messages that are reported as warnings instead of failing the set, can be given multiple times")
+
+	flags.BoolVar(&opts.watch, "watch", false, "keep running and extract sets as they appear in the directories")
+	flags.DurationVar(&opts.debounce, "debounce", 5*time.Second, "how long a directory has to be unchanged in watch mode before it is checked")
+
+	flags.Func("newer", "only check directories modified after this, either a duration ago like 24h or an RFC3339 timestamp", func(v string) error {
+		newer, err := parseNewer(v, time.Now())
+		opts.newer = newer
+		return err
+	})
+	flags.DurationVar(&opts.settle, "settle", 0, "skip sets with volumes that changed within this duration, eg. while downloading")
+	flags.Func("min-size", "skip sets whose volumes add up to less than this size, eg. 100MB or 1.5GB", func(v string) error {
+		size, err := rary.ParseSize(v)
+		opts.minSize = size
+		return err
+	})
+
+	opts.exclude = append(opts.exclude, scanner.DefaultExclude...)
+	flags.Var(&opts.exclude, "exclude", "directory name pattern to skip, can be given multiple times and adds to the defaults")
+	flags.IntVar(&opts.maxDepth, "max-depth", 0, "number of directory levels below each dir that are scanned, 0 for no limit")
+	flags.BoolVar(&opts.followLinks, "follow-symlinks", false, "scan symlinked directories, skipping links that loop back to a directory being scanned")
+
+	flags.Var(&opts.includeExts, "include-ext", "only consider files with this extension besides archive volumes and checksum files, can be given multiple times")
+	flags.Var(&opts.excludeExts, "exclude-ext", "ignore files with this extension, except archive volumes and checksum files, can be given multiple times")
+
+	flags.BoolVar(&opts.relative, "relative-paths", false, "match checksum entries by their path relative to the directory, for sets listing files in subdirectories")
+
+	flags.BoolVar(&opts.recursive, "recursive", false, "check extracted directories again for archives that were packed inside archives")
+	flags.IntVar(&opts.maxLevels, "max-levels", 3, "maximum number of nested archive levels to extract with -recursive")
+
+	flags.StringVar(&opts.formatList, "formats", "rar", "comma separated archive formats to extract, out of "+strings.Join(rary.SupportedFormats(), ", "))
+
+	return flags
+}
+
+// parseFlags applies the defaults, then the config file and then the flags given on the command line. The flags
+// are parsed twice as the config file has to be known before the values in it can be overridden
+func parseFlags(args []string) (*options, error) {
+	first := options{}
+	pre := newFlagSet(args[0], &first)
+	pre.SetOutput(io.Discard)
+	pre.Parse(args[1:])
+
+	opts := options{}
+	flags := newFlagSet(args[0], &opts)
+	if err := loadConfig(flags, first.configFile); err != nil {
+		return nil, err
+	}
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return nil, errUsage
+	}
+
+	for _, dir := range append(opts.dirFlags, flags.Args()...) {
+		if dir == "-" {
+			opts.stdin = true
+			continue
 		}
-		if d.IsDir() {
-			dirs = append(dirs, path)
+		opts.dirs = append(opts.dirs, dir)
+	}
+	if len(opts.dirs) == 0 && !opts.stdin {
+		flags.Usage()
+		return nil, errUsage
+	}
+	if opts.stdin && opts.watch {
+		return nil, fmt.Errorf("-watch can't be used when reading directories from stdin")
+	}
+
+	roots, err := dedupeRoots(opts.dirs)
+	if err != nil {
+		return nil, err
+	}
+	opts.dirs = roots
+
+	if opts.maxDepth < 0 {
+		return nil, fmt.Errorf("-max-depth can't be negative")
+	}
+
+	if opts.format != "text" && opts.format != "json" {
+		return nil, fmt.Errorf("unknown format %q", opts.format)
+	}
+
+	for _, f := range strings.Split(opts.formatList, ",") {
+		if !supportedFormat(strings.TrimSpace(f)) {
+			return nil, fmt.Errorf("unknown archive format %q", f)
 		}
+		opts.formats = append(opts.formats, strings.TrimSpace(f))
+	}
 
-		return nil
+	return &opts, nil
+}
+
+// parseNewer parses the -newer flag as a duration before now or as an RFC3339 timestamp
+func parseNewer(v string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected a duration or RFC3339 timestamp but got %q", v)
+	}
+
+	return t, nil
+}
+
+func supportedFormat(name string) bool {
+	for _, f := range rary.SupportedFormats() {
+		if f == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dedupeRoots cleans the roots and drops any root that is contained in another so that no directory is scanned twice
+func dedupeRoots(dirs []string) ([]string, error) {
+	abs := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		p, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		abs = append(abs, p)
+	}
+	// a root sorts before the directories within it, but not always right before them: '/m/media-old' sorts between
+	// '/m/media' and '/m/media/tv'
+	sort.Strings(abs)
+
+	roots := []string{}
+	for _, dir := range abs {
+		if !withinAny(roots, dir) {
+			roots = append(roots, dir)
+		}
+	}
+
+	return roots, nil
+}
+
+func withinAny(roots []string, dir string) bool {
+	for _, root := range roots {
+		if isWithin(root, dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isWithin(root, dir string) bool {
+	rel, err := filepath.Rel(root, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (o *options) output() io.Writer {
+	if o.format == "json" {
+		return os.Stderr
+	}
+
+	return os.Stdout
+}
+
+func (o *options) scanOptions() scanner.Options {
+	return scanner.Options{
+		FollowSymlinks: o.followLinks,
+		MaxDepth:       o.maxDepth,
+		Exclude:        o.exclude,
+		Newer:          o.newer,
+		Notify:         o.notify(scanErrors),
+	}
+}
+
+func (o *options) snapshotOptions() rary.SnapshotOptions {
+	return rary.SnapshotOptions{
+		IncludeExts:   o.includeExts,
+		ExcludeExts:   o.excludeExts,
+		RelativePaths: o.relative,
+	}
+}
+
+// saveState writes the state file when -state is set. Failing to save is reported but does not stop the run
+func saveState(opts *options) {
+	if opts.state == nil {
+		return
+	}
+
+	if err := opts.state.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save state: %v\n", err)
+	}
+}
+
+// checkDir runs FindUnrarable on a single directory and records the outcome in the report
+func checkDir(ctx context.Context, report *rary.ScanReport, target string, opts *options) {
+	dir, err := rary.NewDirSnapshot(target, opts.snapshotOptions())
+	if err != nil {
+		opts.log.Error("failed to snapshot", "dir", target, "err", err)
+		report.Add(target, nil, err)
+		return
+	}
+
+	var sets []*rary.Unrar
+	if opts.state != nil && !opts.force && opts.state.resolved(dir, target) {
+		err = errUnchanged
+	} else {
+		sets, err = rary.FindUnrarable(ctx, dir)
+		if outcome, ok := resolvedOutcome(err); ok && opts.state != nil {
+			opts.state.record(dir, target, outcome)
+		}
+	}
+	reportDir(report, target, dir, sets, err, opts)
+}
+
+// reportDir logs the outcome of checking the directory and records it in the report
+func reportDir(report *rary.ScanReport, target string, dir *rary.DirSnapshot, sets []*rary.Unrar, err error, opts *options) {
+	opts.log.Info("scanned", "dir", target)
+	if checksums := rary.ChecksumFiles(dir); len(checksums) > 1 {
+		opts.log.Warn("multiple checksum files", "dir", target, "files", checksums)
+		if opts.verbose {
+			fmt.Fprintf(os.Stderr, "warning: %s has %d checksum files, their entries were merged: %s\n", target, len(checksums), strings.Join(checksums, ", "))
+		}
+	}
+	if err != nil {
+		skip := rary.NewSkipInfo(target, err)
+		opts.log.Info("skipped", "dir", target, "reason", skip.Reason, "missing", skip.Missing)
+		if opts.verbose {
+			printSkip(target, skip)
+		}
+	}
+	report.Add(target, sets, err)
+}
+
+func printSkip(target string, skip rary.SkipInfo) {
+	if errors.Is(skip.Err, rary.ErrAlreadyExtracted) {
+		fmt.Fprintf(os.Stderr, "done %s\n", target)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "skipping %s: %s\n", target, skip.Reason)
+	for _, missing := range skip.Missing {
+		fmt.Fprintf(os.Stderr, "  missing %s\n", missing)
+	}
+}
+
+// recordExtracted records the directory as resolved in the state, hashing it again as extracting changed it
+func recordExtracted(opts *options, path string) {
+	if opts.state == nil || opts.dryRun {
+		return
+	}
+
+	dir, err := rary.NewDirSnapshot(path, opts.snapshotOptions())
+	if err == nil {
+		opts.state.record(dir, path, "extracted")
+	}
+}
+
+// extract runs DoAll on the candidates of the report and prints the report
+func extract(ctx context.Context, report *rary.ScanReport, opts *options) error {
+	results, err := rary.DoAll(ctx, report.Candidates, opts.output(), rary.DoAllOptions{
+		Concurrency:     opts.concurrency,
+		DryRun:          opts.dryRun,
+		Notify:          opts.notify(func(ev any) { logEvent(opts.log, ev) }),
+		QuarantineDir:   opts.quarantine,
+		WarningPatterns: opts.warnings,
+		Stream:          opts.stream,
+		FailFast:        opts.failFast,
 	})
+	// a directory is only resolved once every set in it was extracted, a failed disc has to be tried again
+	failed := make(map[string]bool)
+	for _, result := range results {
+		if result.Err != nil {
+			failed[result.WorkDir] = true
+		}
+	}
+	for _, result := range results {
+		if errors.Is(result.Err, rary.ErrLocked) {
+			continue
+		}
+		// with -fail-fast the sets that had not started are skipped, not failed
+		if errors.Is(result.Err, rary.ErrNotStarted) {
+			opts.log.Info("skipped", "dir", result.WorkDir, "archive", result.Archive, "reason", result.Err)
+			continue
+		}
+		if result.Err != nil {
+			opts.log.Error("failed", "dir", result.WorkDir, "archive", result.Archive, "err", result.Err)
+		} else {
+			opts.log.Info("extracted", "dir", result.WorkDir, "archive", result.Archive, "duration", result.Duration, "warnings", result.Warnings)
+			if !failed[result.WorkDir] {
+				recordExtracted(opts, result.WorkDir)
+			}
+		}
+	}
+	report.Results = results
+	report.DryRun = opts.dryRun
+	saveState(opts)
+	report.Render(os.Stderr)
+	if jsonErr := addDecisions(opts, report); jsonErr != nil {
+		return jsonErr
+	}
 
-	return dirs
+	return err
 }
 
-func run(args []string) error {
-	targetDir := os.Args[1]
-	allDirs := allDirs(targetDir)
+// addDecisions collects the decisions of the report for writeDecisions. In watch mode there is no end of the run to
+// wait for so they are written straight away, one JSON object per line
+func addDecisions(opts *options, report *rary.ScanReport) error {
+	if opts.format != "json" {
+		return nil
+	}
+
+	if opts.watch {
+		enc := json.NewEncoder(os.Stdout)
+		for _, d := range report.Decisions() {
+			if err := enc.Encode(d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	opts.decisions = append(opts.decisions, report.Decisions()...)
+	return nil
+}
+
+// writeDecisions writes the decisions of the whole run as a single JSON array
+func writeDecisions(opts *options) error {
+	if opts.format != "json" || opts.watch {
+		return nil
+	}
+
+	decisions := opts.decisions
+	if decisions == nil {
+		decisions = []rary.Decision{}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(decisions)
+}
+
+// snapshotCandidates takes a snapshot of the directory of every candidate, which extractNested compares against
+// once they were extracted
+func snapshotCandidates(report *rary.ScanReport, opts *options) map[string]*rary.DirSnapshot {
+	snapshots := make(map[string]*rary.DirSnapshot)
+	for _, candidate := range report.Candidates {
+		if _, ok := snapshots[candidate.WorkDir()]; ok {
+			continue
+		}
+		if dir, err := rary.NewDirSnapshot(candidate.WorkDir(), opts.snapshotOptions()); err == nil {
+			snapshots[candidate.WorkDir()] = dir
+		}
+	}
+
+	return snapshots
+}
+
+// extractNested checks the directories that were extracted successfully for archives that came out of the archives,
+// until no new sets appear or the maximum number of levels is reached. Only the files a level added to a directory,
+// compared to the snapshot in before, are checked so the sets that were already extracted are not considered again
+func extractNested(ctx context.Context, report *rary.ScanReport, before map[string]*rary.DirSnapshot, opts *options) error {
+	var err error
+	levels := 1
+	for levels < opts.maxLevels {
+		next := rary.ScanReport{}
+		after := make(map[string]*rary.DirSnapshot)
+		for _, result := range report.Results {
+			old, ok := before[result.WorkDir]
+			if result.Err != nil || !ok || after[result.WorkDir] != nil {
+				continue
+			}
+			dir, snapErr := rary.NewDirSnapshot(result.WorkDir, opts.snapshotOptions())
+			if snapErr != nil {
+				continue
+			}
+			after[result.WorkDir] = dir
+
+			diff := rary.Diff(old, dir)
+			added := dir.Subset(append(diff.Added, diff.Modified...))
+			if _, archiveErr := rary.PrimaryArchive(added); archiveErr != nil {
+				continue
+			}
+			sets, findErr := rary.FindUnrarable(ctx, added)
+			reportDir(&next, result.WorkDir, added, sets, findErr, opts)
+		}
+		if len(next.Candidates) == 0 {
+			break
+		}
+
+		if e := extract(ctx, &next, opts); e != nil {
+			err = e
+			if opts.failFast {
+				break
+			}
+		}
+		report = &next
+		before = after
+		levels++
+	}
+	fmt.Fprintf(os.Stderr, "processed %d levels of archives\n", levels)
+
+	return err
+}
 
-	unrars := make([]*rary.Unrar, 0)
+// checkStdin checks every directory listed in r, one per line, without scanning below them. The paths are made
+// absolute so that a directory listed twice, eg. with and without a trailing slash, is only checked once
+func checkStdin(ctx context.Context, report *rary.ScanReport, r io.Reader, opts *options) error {
+	seen := make(map[string]bool)
+	lines := bufio.NewScanner(r)
+	for lines.Scan() {
+		target := strings.TrimSpace(lines.Text())
+		if target == "" {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-	skipCount := 0
-	for _, target := range allDirs {
-		dir, _ := rary.NewDirSnapshot(target)
-		unrar, err := rary.FindUnrarable(dir)
+		target, err := filepath.Abs(target)
 		if err != nil {
-			//fmt.Fprintf(os.Stderr, "skipping %s\n", target)
-			skipCount++
+			return err
+		}
+		if seen[target] {
 			continue
 		}
+		seen[target] = true
+
+		checkDir(ctx, report, target, opts)
+	}
+
+	return lines.Err()
+}
+
+// notify returns a Notify func that passes every event to handle and forwards it to the -events sink
+func (o *options) notify(handle func(ev any)) func(ev any) {
+	return func(ev any) {
+		handle(ev)
+		if o.sink != nil {
+			o.sink.Notify(ev)
+		}
+	}
+}
 
-		unrars = append(unrars, unrar)
+// scanErrors prints the directories the scanner could not read
+func scanErrors(ev any) {
+	if e, ok := ev.(event.DirScanError); ok {
+		fmt.Fprintf(os.Stderr, "scan %s: %v\n", e.Path, e.Err)
 	}
-	fmt.Fprintf(os.Stderr, "skipped %d dirs\n", skipCount)
+}
 
-	return rary.DoAll(unrars, os.Stdout)
+// scanRoots checks every directory found below the roots, with up to -scan-concurrency directories checked at a time
+func scanRoots(ctx context.Context, report *rary.ScanReport, opts *options) {
+	rary.HuntInto(ctx, report, opts.dirs, rary.HuntOptions{
+		Scan:    opts.scanOptions(),
+		Workers: opts.scanWorkers,
+		Check: func(ctx context.Context, report *rary.ScanReport, found event.DirFound) {
+			if opts.sink != nil {
+				opts.sink.Notify(found)
+			}
+			checkDir(ctx, report, found.Path, opts)
+		},
+	})
+}
+
+func run(ctx context.Context, opts *options) error {
+	log, closer, err := openLog(opts.logFile)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer closer.Close()
+	opts.log = log
+
+	if opts.events != "" {
+		if opts.sink, err = sink.Open(opts.events); err != nil {
+			return fmt.Errorf("failed to open event sink: %w", err)
+		}
+		defer func() {
+			if err := opts.sink.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "events: %v\n", err)
+			}
+		}()
+	}
+
+	if opts.stateFile != "" {
+		if opts.state, err = loadState(opts.stateFile); err != nil {
+			return err
+		}
+	}
+
+	rary.Configure(rary.Config{UnrarBinary: opts.unrarBin, Formats: opts.formats})
+	if opts.settle > 0 {
+		rary.RegisterCriteria("settled", rary.AnyCriteria(rary.Settled(opts.settle)))
+	}
+	if opts.minSize > 0 {
+		rary.RegisterCriteria("min-size", rary.AnyCriteria(rary.MinSetSize(opts.minSize)))
+	}
+
+	report := rary.ScanReport{}
+	if opts.stdin {
+		if err := checkStdin(ctx, &report, os.Stdin, opts); err != nil {
+			return err
+		}
+	}
+	scanRoots(ctx, &report, opts)
+
+	var before map[string]*rary.DirSnapshot
+	if opts.recursive {
+		before = snapshotCandidates(&report, opts)
+	}
+	err = extract(ctx, &report, opts)
+	if opts.recursive && (err == nil || !opts.failFast) {
+		if nestedErr := extractNested(ctx, &report, before, opts); nestedErr != nil && err == nil {
+			err = nestedErr
+		}
+	}
+	if !opts.watch {
+		if jsonErr := writeDecisions(opts); jsonErr != nil && err == nil {
+			err = jsonErr
+		}
+		return err
+	}
+	if err != nil && opts.failFast {
+		return err
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	return watch(ctx, opts)
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		panic("need one argument")
+	opts, err := parseFlags(os.Args)
+	if err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		os.Exit(2)
 	}
 
-	if err := run(os.Args); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, opts); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	rary "github.com/burmudar/rar-hunter/rary"
+)
+
+func TestDedupeRoots(t *testing.T) {
+	root := t.TempDir()
+	tests := []struct {
+		name string
+		dirs []string
+		want []string
+	}{
+		{
+			name: "trailing slash",
+			dirs: []string{root + "/media/", root + "/media"},
+			want: []string{root + "/media"},
+		},
+		{
+			name: "overlapping roots",
+			dirs: []string{root + "/media/tv", root + "/media", root + "/media/tv/show/"},
+			want: []string{root + "/media"},
+		},
+		{
+			name: "dot segments",
+			dirs: []string{root + "/media/./tv/../movies", root + "/media/movies"},
+			want: []string{root + "/media/movies"},
+		},
+		{
+			name: "sibling sorted between a root and its subdirectory",
+			dirs: []string{root + "/media/tv", root + "/media-old", root + "/media"},
+			want: []string{root + "/media", root + "/media-old"},
+		},
+		{
+			name: "siblings with a common prefix",
+			dirs: []string{root + "/media", root + "/media2"},
+			want: []string{root + "/media", root + "/media2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dedupeRoots(tt.dirs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dedupeRoots(%v) = %v, want %v", tt.dirs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckStdinDedupes(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	input := strings.Join([]string{
+		root + "/a",
+		root + "/a/",
+		"  a  ",
+		"./a/.",
+		"",
+		root + "//b",
+		"b/",
+	}, "\n")
+
+	opts := &options{log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	report := rary.ScanReport{}
+	if err := checkStdin(context.Background(), &report, strings.NewReader(input), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	checked := []string{}
+	for _, skip := range report.Skipped {
+		checked = append(checked, skip.Path)
+	}
+	sort.Strings(checked)
+	want := []string{filepath.Join(root, "a"), filepath.Join(root, "b")}
+	if report.Scanned != len(want) || !reflect.DeepEqual(checked, want) {
+		t.Errorf("checked %d directories %v, want %v", report.Scanned, checked, want)
+	}
+}
+
+// failingSets configures an unrar that lists movie.mkv for every archive and fails every test and extraction, and
+// returns a report holding a set in each of n new directories
+func failingSets(t *testing.T, n int) *rary.ScanReport {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake unrar is a shell script")
+	}
+	unrar := filepath.Join(t.TempDir(), "unrar")
+	script := "#!/bin/sh\ncase \"$1\" in\nlb) echo movie.mkv;;\n*) echo \"CRC failed\" >&2; exit 3;;\nesac\n"
+	if err := os.WriteFile(unrar, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	rary.Configure(rary.Config{UnrarBinary: unrar})
+	t.Cleanup(func() { rary.Configure(rary.Config{}) })
+
+	report := &rary.ScanReport{}
+	for i := 0; i < n; i++ {
+		root := t.TempDir()
+		for name, content := range map[string]string{"a.rar": "a.rar", "a.sfv": "a.rar 0badc0de\n"} {
+			if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dir, err := rary.NewDirSnapshot(root, rary.SnapshotOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sets, err := rary.FindUnrarable(context.Background(), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report.Add(root, sets, nil)
+	}
+
+	return report
+}
+
+func TestExtractFailFastSkipsNotStarted(t *testing.T) {
+	report := failingSets(t, 3)
+
+	var logs strings.Builder
+	opts := &options{
+		log:         slog.New(slog.NewTextHandler(&logs, nil)),
+		concurrency: 1,
+		failFast:    true,
+	}
+	if err := extract(context.Background(), report, opts); err == nil {
+		t.Fatal("extract did not report the failed set")
+	}
+
+	if failed := strings.Count(logs.String(), "msg=failed"); failed != 1 {
+		t.Errorf("logged %d failed sets, want 1:\n%s", failed, logs.String())
+	}
+	if skipped := strings.Count(logs.String(), "msg=skipped"); skipped != 2 {
+		t.Errorf("logged %d skipped sets, want 2:\n%s", skipped, logs.String())
+	}
+}
+
+func TestCheckDirSnapshotError(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "gone")
+	opts := &options{log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	report := rary.ScanReport{}
+	checkDir(context.Background(), &report, target, opts)
+
+	if report.Scanned != 1 || len(report.Skipped) != 1 || report.Skipped[0].Path != target {
+		t.Fatalf("skipped %v, want %s", report.Skipped, target)
+	}
+	if err := report.Skipped[0].Err; !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("skipped with %v, want the snapshot error", err)
+	}
+	if len(report.Candidates) != 0 {
+		t.Errorf("candidates = %v, want none", report.Candidates)
+	}
+}
+
+func TestExtractDryRunDecisions(t *testing.T) {
+	opts := &options{
+		log:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		format: "json",
+		dryRun: true,
+	}
+	// every level of a -recursive run adds to the same decisions
+	levels := []*rary.ScanReport{failingSets(t, 2), failingSets(t, 1)}
+	levels[1].Add(filepath.Join(t.TempDir(), "empty"), nil, rary.ErrNoChecksumFile)
+	for _, report := range levels {
+		if err := extract(context.Background(), report, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	actions := []string{}
+	for _, d := range opts.decisions {
+		actions = append(actions, fmt.Sprintf("%s dry_run=%t", d.Action, d.DryRun))
+	}
+	want := []string{"extract dry_run=true", "extract dry_run=true", "skip dry_run=false", "extract dry_run=true"}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("decisions = %v, want %v", actions, want)
+	}
+}
+
+func TestScanRootsMaxDepth(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a", "b", "c"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseFlags([]string{"rar-hunter", "-config", os.DevNull, "-max-depth", "1", root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	report := rary.ScanReport{}
+	scanRoots(context.Background(), &report, opts)
+
+	scanned := []string{}
+	for _, skip := range report.Skipped {
+		scanned = append(scanned, skip.Path)
+	}
+	sort.Strings(scanned)
+	want := []string{root, filepath.Join(root, "a")}
+	if !reflect.DeepEqual(scanned, want) {
+		t.Errorf("scanned %v, want %v", scanned, want)
+	}
+}
+
+func TestScanRootsFollowSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs extra privileges on windows")
+	}
+	library := t.TempDir()
+	seasons := t.TempDir()
+	if err := os.Mkdir(filepath.Join(seasons, "S01"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(seasons, "S01"), filepath.Join(library, "S01")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		flags   []string
+		scanned int
+	}{
+		{flags: nil, scanned: 1},
+		{flags: []string{"-follow-symlinks"}, scanned: 2},
+	}
+	for _, tt := range tests {
+		args := append([]string{"rar-hunter", "-config", os.DevNull}, tt.flags...)
+		opts, err := parseFlags(append(args, library))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.log = slog.New(slog.NewTextHandler(io.Discard, nil))
+		report := rary.ScanReport{}
+		scanRoots(context.Background(), &report, opts)
+
+		if report.Scanned != tt.scanned {
+			t.Errorf("%v scanned %d directories, want %d", tt.flags, report.Scanned, tt.scanned)
+		}
+	}
+}
+
+func TestExtractRecordsDirOnceEverySetSucceeded(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake unrar is a shell script")
+	}
+	tests := []struct {
+		name     string
+		failing  string
+		recorded bool
+	}{
+		{name: "every disc extracted", failing: "none.rar", recorded: true},
+		{name: "second disc failed", failing: "CD2/cd2.rar", recorded: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unrar := filepath.Join(t.TempDir(), "unrar")
+			script := "#!/bin/sh\ncase \"$1\" in lb) echo movie.mkv; exit 0;; esac\n" +
+				"case \"$*\" in *'" + tt.failing + "'*) echo \"CRC failed\" >&2; exit 3;; esac\n"
+			if err := os.WriteFile(unrar, []byte(script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			rary.Configure(rary.Config{UnrarBinary: unrar})
+			t.Cleanup(func() { rary.Configure(rary.Config{}) })
+
+			root := t.TempDir()
+			for _, disc := range []string{"CD1", "CD2"} {
+				if err := os.Mkdir(filepath.Join(root, disc), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			files := map[string]string{"CD1/cd1.rar": "cd1", "CD2/cd2.rar": "cd2", "a.sfv": "CD1/cd1.rar 0badc0de\nCD2/cd2.rar 0badc0de\n"}
+			for name, content := range files {
+				if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			opts := &options{
+				log:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+				relative: true,
+				state:    &state{path: filepath.Join(t.TempDir(), "state.json"), Dirs: map[string]stateEntry{}},
+			}
+			report := rary.ScanReport{}
+			checkDir(context.Background(), &report, root, opts)
+			if len(report.Candidates) != 2 {
+				t.Fatalf("found %d sets, want a set per disc", len(report.Candidates))
+			}
+			extract(context.Background(), &report, opts)
+
+			if _, recorded := opts.state.Dirs[root]; recorded != tt.recorded {
+				t.Errorf("recorded %s = %t, want %t", root, recorded, tt.recorded)
+			}
+		})
+	}
+}
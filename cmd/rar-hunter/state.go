@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	rary "github.com/burmudar/rar-hunter/rary"
+)
+
+var errUnchanged = errors.New("unchanged since it was resolved by a previous run")
+
+// stateEntry is the last outcome recorded for a directory, along with the hash of the snapshot it was recorded for
+type stateEntry struct {
+	Hash    string `json:"hash"`
+	Outcome string `json:"outcome"`
+}
+
+// state remembers which directories were resolved by previous runs so that they are not listed again while their
+// contents stay the same. A directory is resolved once it is extracted or found to be extracted already
+type state struct {
+	path string
+	mu   sync.Mutex
+	Dirs map[string]stateEntry `json:"dirs"`
+}
+
+// loadState reads the state file at path. A missing file is an empty state
+func loadState(path string) (*state, error) {
+	s := &state{path: path, Dirs: make(map[string]stateEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	if s.Dirs == nil {
+		s.Dirs = make(map[string]stateEntry)
+	}
+
+	return s, nil
+}
+
+func (s *state) resolved(dir *rary.DirSnapshot, path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.Dirs[path]
+	return ok && entry.Hash == dir.Hash()
+}
+
+func (s *state) record(dir *rary.DirSnapshot, path, outcome string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Dirs[path] = stateEntry{Hash: dir.Hash(), Outcome: outcome}
+}
+
+// save writes the state to a temporary file and renames it over the state file so that an interrupted run never
+// leaves a truncated file behind
+func (s *state) save() error {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// resolvedOutcome returns the outcome to record for the result of FindUnrarable, or false when the directory is
+// not resolved and should be checked again by the next run
+func resolvedOutcome(err error) (string, bool) {
+	var criteriaErr *rary.CriteriaError
+	switch {
+	case errors.Is(err, rary.ErrAlreadyExtracted):
+		return "already extracted", true
+	case errors.As(err, &criteriaErr) && criteriaErr.Name == "already-unrared":
+		return "already unrared", true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	rary "github.com/burmudar/rar-hunter/rary"
+	"github.com/burmudar/rar-hunter/rary/event"
+	"github.com/burmudar/rar-hunter/rary/scanner"
+)
+
+// watch checks every directory that changes below the roots and extracts it once it becomes unrarable. It only
+// returns once ctx is cancelled, or with -fail-fast when a set fails to extract
+func watch(ctx context.Context, opts *options) error {
+	watchOpts := scanner.WatchOptions{
+		Debounce: opts.debounce,
+		Exclude:  opts.exclude,
+		Notify: opts.notify(func(ev any) {
+			if e, ok := ev.(event.DirScanError); ok {
+				fmt.Fprintf(os.Stderr, "watch %s: %v\n", e.Path, e.Err)
+			}
+		}),
+	}
+
+	changes := make(chan event.DirChanged)
+	var wg sync.WaitGroup
+	for _, root := range opts.dirs {
+		ch, err := scanner.Watch(ctx, root, watchOpts)
+		if err != nil {
+			return fmt.Errorf("failed to watch %s: %w", root, err)
+		}
+
+		wg.Add(1)
+		go func(ch <-chan event.DirChanged) {
+			defer wg.Done()
+			for changed := range ch {
+				changes <- changed
+			}
+		}(ch)
+	}
+	go func() {
+		wg.Wait()
+		close(changes)
+	}()
+
+	for changed := range changes {
+		report := rary.ScanReport{}
+		checkDir(ctx, &report, changed.Path, opts)
+		if len(report.Candidates) == 0 {
+			saveState(opts)
+			if err := addDecisions(opts, &report); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := extract(ctx, &report, opts); err != nil {
+			if opts.failFast {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+
+	return nil
+}
@@ -1,3 +1,10 @@
 module github.com/burmudar/rar-hunter
 
-go 1.18
+go 1.21
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/fsnotify/fsnotify v1.7.0
+)
+
+require golang.org/x/sys v0.4.0 // indirect
@@ -0,0 +1,172 @@
+package rary
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Archiver runs the tool that lists, tests and extracts archives. By default the tool of the format is run, set
+// Config.Archiver to replace it
+type Archiver interface {
+	// List returns the names of the files stored in the archive at path
+	List(ctx context.Context, path string) ([]string, error)
+	// Extract extracts an archive and returns what the tool wrote to stdout
+	Extract(ctx context.Context, req ExtractRequest) ([]byte, error)
+	// Test checks the integrity of the archive at path. A corrupt archive is reported as ErrCorruptArchive, a set that
+	// cannot be tested as a whole, eg. for a missing volume, with another error. The test passes when the tool only
+	// reported messages matching the warnings
+	Test(ctx context.Context, path string, warnings []*regexp.Regexp) error
+}
+
+// ExtractRequest describes a single extraction for an Archiver
+type ExtractRequest struct {
+	// Archive is the name of the primary volume, relative to Dir
+	Archive string
+	// Dir is the directory the archive is in
+	Dir string
+	// Dest is the directory the files are extracted to. When empty files are extracted into Dir
+	Dest      string
+	Mode      ExtractMode
+	Overwrite OverwritePolicy
+	// Notify receives the event.ExtractProgress events of the extraction, it may be nil
+	Notify func(ev any)
+	// Warnings match messages of the tool that are passed to Warn instead of failing the extraction
+	Warnings []*regexp.Regexp
+	Warn     func(message string)
+	// Stdout and Stderr receive what the tool writes as it is produced, they may be nil
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// ArchiveEntry is a file stored in an archive. Fields the archive tool does not print are left at their zero value
+type ArchiveEntry struct {
+	Name       string
+	Size       int64
+	Packed     int64
+	ModTime    time.Time
+	CRC        string
+	Attributes string
+	Dir        bool
+}
+
+// EntryLister is implemented by an Archiver that can list the entries of an archive along with their sizes
+type EntryLister interface {
+	Entries(ctx context.Context, path string) ([]ArchiveEntry, error)
+}
+
+// commandArchiver is an Archiver that extracts by running a single command, which DoAll prints for a dry run
+type commandArchiver interface {
+	command(ctx context.Context, req ExtractRequest) *exec.Cmd
+}
+
+// runTest runs the integrity test cmd of the tool for the archive at path. A failing test is reported as the error
+// its stderr is classified as, eg. ErrMissingVolume, or ErrCorruptArchive when nothing was recognised. A missing
+// binary is ErrUnrarNotFound. Like an extraction, the test passes when the tool exits with 1 having only written
+// messages matching the warnings to stderr
+func runTest(ctx context.Context, cmd *exec.Cmd, path string, warnings []*regexp.Regexp) error {
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+	cmd.WaitDelay = waitDelay
+
+	err := cmd.Run()
+	stderr, found := splitWarnings(stderrBuf.String(), warnings)
+	classified := classifyStderr(stderr)
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("%w: %s", ErrUnrarNotFound, cmd.Path)
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(found) > 0 && stderr == "":
+		return nil
+	case classified != nil:
+		return fmt.Errorf("%w: %s", classified, stderr)
+	case errors.As(err, &exitErr):
+		return fmt.Errorf("%w: %s exited with %d: %s", ErrCorruptArchive, path, exitErr.ExitCode(), stderr)
+	default:
+		return fmt.Errorf("failed to test %s: %w", path, err)
+	}
+}
+
+// listLines runs cmd with a timeout of listTimeout and returns the non empty lines it printed
+func listLines(ctx context.Context, cmd func(ctx context.Context) *exec.Cmd) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, listTimeout)
+	defer cancel()
+
+	c := cmd(ctx)
+	c.WaitDelay = waitDelay
+	out, err := c.CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("list command failure: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("list command failure: %w", err)
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, nil
+}
+
+// ListEntries returns the entries of the archive. It fails for formats whose Archiver is not an EntryLister
+func ListEntries(archive string) ([]ArchiveEntry, error) {
+	lister, ok := archiverFor(archive).(EntryLister)
+	if !ok {
+		return nil, fmt.Errorf("listing entries of %s is not supported", archive)
+	}
+
+	return lister.Entries(context.Background(), archive)
+}
+
+// PrimaryEntry returns the largest file in the archive, which is the payload of a release rather than the .nfo or
+// .sfv packed alongside it. Archivers that cannot list sizes return the first file they list
+func PrimaryEntry(archive string) (string, error) {
+	return primaryEntry(context.Background(), archive)
+}
+
+func primaryEntry(ctx context.Context, archive string) (string, error) {
+	a := archiverFor(archive)
+	lister, ok := a.(EntryLister)
+	if !ok {
+		names, err := a.List(ctx, archive)
+		if err != nil {
+			return "", err
+		}
+		if len(names) == 0 {
+			return "", fmt.Errorf("no files listed in %s", archive)
+		}
+		return names[0], nil
+	}
+
+	entries, err := lister.Entries(ctx, archive)
+	if err != nil {
+		return "", err
+	}
+
+	var largest *ArchiveEntry
+	for i, entry := range entries {
+		if !entry.Dir && (largest == nil || entry.Size > largest.Size) {
+			largest = &entries[i]
+		}
+	}
+	if largest == nil {
+		return "", fmt.Errorf("no files listed in %s", archive)
+	}
+
+	return largest.Name, nil
+}
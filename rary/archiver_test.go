@@ -0,0 +1,132 @@
+package rary
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeArchiver is an Archiver for tests that knows the files of every archive by its base name. Extracting an
+// archive writes those files to the destination
+type fakeArchiver struct {
+	mu sync.Mutex
+	// files are the names stored in each archive
+	files map[string][]string
+	// errs fail the extraction of an archive
+	errs map[string]error
+	// corrupt archives fail Test with ErrCorruptArchive
+	corrupt map[string]bool
+	// delay is how long extracting takes, so that extractions overlap
+	delay time.Duration
+	// onExtract is called with every request before the archive is extracted
+	onExtract func(req ExtractRequest)
+	// extracted are the paths of the archives that were extracted, in the order they were extracted
+	extracted []string
+}
+
+func (a *fakeArchiver) List(ctx context.Context, path string) ([]string, error) {
+	files, ok := a.files[filepath.Base(path)]
+	if !ok {
+		return nil, fmt.Errorf("%s is not an archive", path)
+	}
+
+	return files, nil
+}
+
+func (a *fakeArchiver) Extract(ctx context.Context, req ExtractRequest) ([]byte, error) {
+	a.mu.Lock()
+	a.extracted = append(a.extracted, filepath.Join(req.Dir, req.Archive))
+	a.mu.Unlock()
+
+	if a.onExtract != nil {
+		a.onExtract(req)
+	}
+	time.Sleep(a.delay)
+	if err := a.errs[filepath.Base(req.Archive)]; err != nil {
+		return nil, err
+	}
+
+	dest := req.Dest
+	if dest == "" {
+		dest = req.Dir
+	}
+	out := fmt.Sprintf("Extracting from %s\n", req.Archive)
+	for _, name := range a.files[filepath.Base(req.Archive)] {
+		if err := os.WriteFile(filepath.Join(dest, name), []byte("payload"), 0644); err != nil {
+			return []byte(out), err
+		}
+		out += fmt.Sprintf("Extracting  %s  OK\n", name)
+	}
+
+	return []byte(out + "All OK\n"), nil
+}
+
+func (a *fakeArchiver) Test(ctx context.Context, path string, warnings []*regexp.Regexp) error {
+	if a.corrupt[filepath.Base(path)] {
+		return fmt.Errorf("%w: %s", ErrCorruptArchive, path)
+	}
+
+	return nil
+}
+
+// useArchiver configures a for the duration of the test
+func useArchiver(t *testing.T, a Archiver) {
+	t.Helper()
+
+	previous := config
+	Configure(Config{Archiver: a})
+	t.Cleanup(func() { Configure(previous) })
+}
+
+// writeFiles creates the files in dir, with their names as their content. Names ending in / are created as
+// directories
+func writeFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeSFV creates an SFV in dir listing the files with a made up crc
+func writeSFV(t *testing.T, dir, name string, files ...string) {
+	t.Helper()
+
+	content := ""
+	for _, file := range files {
+		content += file + " 0badc0de\n"
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFakeArchiverPrimaryEntry(t *testing.T) {
+	useArchiver(t, &fakeArchiver{files: map[string][]string{"a.rar": {"movie.mkv", "movie.nfo"}}})
+
+	name, err := PrimaryEntry(filepath.Join(t.TempDir(), "a.rar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "movie.mkv" {
+		t.Errorf("PrimaryEntry = %q, want movie.mkv", name)
+	}
+}
@@ -0,0 +1,258 @@
+package rary
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var ErrNoChecksumFile = errors.New("no checksum files found")
+
+// ChecksumFile is a sidecar file listing the files of a release along with their checksums
+type ChecksumFile interface {
+	Files() []string
+	Verify(dir *DirSnapshot) ([]ChecksumMismatch, error)
+}
+
+type ChecksumMismatch struct {
+	Filename string
+	Expected string
+	Actual   string
+}
+
+func (m ChecksumMismatch) String() string {
+	return fmt.Sprintf("%s: expected %s got %s", m.Filename, m.Expected, m.Actual)
+}
+
+// HashFile is a checksum file in the GNU coreutils format ie. md5sum, sha1sum and sha256sum
+type HashFile struct {
+	items   map[string]string
+	newHash func() hash.Hash
+}
+
+type checksumFormat struct {
+	ext   string
+	parse func(filename string) (ChecksumFile, error)
+}
+
+var checksumFormats = []checksumFormat{
+	{".sfv", func(filename string) (ChecksumFile, error) {
+		sfv, err := parseSFV(filename)
+		if err != nil {
+			return nil, err
+		}
+		return sfv, nil
+	}},
+	{".md5", hashFileParser(md5.New)},
+	{".sha1", hashFileParser(sha1.New)},
+	{".sha256", hashFileParser(sha256.New)},
+}
+
+func checksumExts() []string {
+	exts := []string{}
+	for _, f := range checksumFormats {
+		exts = append(exts, f.ext)
+	}
+
+	return exts
+}
+
+// findChecksumFile returns the checksum files of the first format found in the dir along with their names. When
+// there are several, eg. one per disc, their entries are merged into a single ChecksumFile
+func findChecksumFile(dir *DirSnapshot) (ChecksumFile, []string, error) {
+	for _, format := range checksumFormats {
+		files := dir.findInRoot(func(item string) bool { return hasExt([]string{format.ext}, item) })
+		if len(files) == 0 {
+			continue
+		}
+		sort.Strings(files)
+
+		merged := mergedChecksumFile{}
+		for _, file := range files {
+			sfv, err := format.parse(dir.Path(file))
+			if err != nil {
+				return nil, files, err
+			}
+			merged = append(merged, sfv)
+		}
+		if len(merged) == 1 {
+			return merged[0], files, nil
+		}
+		return merged, files, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w (%s) in %s", ErrNoChecksumFile, strings.Join(checksumExts(), ", "), dir.root)
+}
+
+// ChecksumFiles returns the names of the checksum files in the dir that FindUnrarable uses. There is more than one
+// when a release ships several files in the same format
+func ChecksumFiles(dir *DirSnapshot) []string {
+	for _, format := range checksumFormats {
+		if files := dir.findInRoot(func(item string) bool { return hasExt([]string{format.ext}, item) }); len(files) > 0 {
+			sort.Strings(files)
+			return files
+		}
+	}
+
+	return nil
+}
+
+// splitChecksumFiles splits the files listed in the checksum file into the archive volumes and everything else, ie.
+// release metadata and the extracted payload
+func splitChecksumFiles(sfv ChecksumFile) ([]string, []string) {
+	volumes := []string{}
+	payload := []string{}
+	for _, f := range sfv.Files() {
+		if isVolume(f) {
+			volumes = append(volumes, f)
+		} else {
+			payload = append(payload, f)
+		}
+	}
+
+	return volumes, payload
+}
+
+// mergedChecksumFile combines the entries of several checksum files in the same directory
+type mergedChecksumFile []ChecksumFile
+
+func (m mergedChecksumFile) Files() []string {
+	seen := make(map[string]bool)
+	files := []string{}
+	for _, c := range m {
+		for _, f := range c.Files() {
+			if !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+func (m mergedChecksumFile) Verify(dir *DirSnapshot) ([]ChecksumMismatch, error) {
+	mismatches := []ChecksumMismatch{}
+	for _, c := range m {
+		found, err := c.Verify(dir)
+		mismatches = append(mismatches, found...)
+		if err != nil {
+			return mismatches, err
+		}
+	}
+
+	return mismatches, nil
+}
+
+// checksumContent strips the UTF-8 byte order mark tools on Windows write at the start of checksum files. The
+// trailing \r of CRLF line endings is removed along with the other whitespace when lines are trimmed
+func checksumContent(data []byte) string {
+	return strings.TrimPrefix(string(data), "\ufeff")
+}
+
+// checksumName normalises a filename listed in a checksum file to the slash separated form used by DirSnapshot.
+// Files written on Windows use backslashes for subdirectories
+func checksumName(name string) string {
+	return path.Clean(strings.ReplaceAll(name, "\\", "/"))
+}
+
+func sortedKeys(items map[string]string) []string {
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func fileDigest(path string, h hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func verifyDigests(dir *DirSnapshot, items map[string]string, newHash func() hash.Hash) ([]ChecksumMismatch, error) {
+	mismatches := []ChecksumMismatch{}
+	for _, name := range sortedKeys(items) {
+		actual, err := fileDigest(dir.Path(name), newHash())
+		if err != nil {
+			return mismatches, fmt.Errorf("failed to compute checksum for %s: %w", name, err)
+		}
+
+		if !strings.EqualFold(items[name], actual) {
+			mismatches = append(mismatches, ChecksumMismatch{
+				Filename: name,
+				Expected: items[name],
+				Actual:   actual,
+			})
+		}
+	}
+
+	return mismatches, nil
+}
+
+func (h *HashFile) Files() []string {
+	return sortedKeys(h.items)
+}
+
+func (h *HashFile) Verify(dir *DirSnapshot) ([]ChecksumMismatch, error) {
+	return verifyDigests(dir, h.items, h.newHash)
+}
+
+var hashLineRe = regexp.MustCompile(`^([[:xdigit:]]+)\s+\*?(.+)$`)
+
+func hashFileParser(newHash func() hash.Hash) func(filename string) (ChecksumFile, error) {
+	return func(filename string) (ChecksumFile, error) {
+		return parseHashFile(filename, newHash)
+	}
+}
+
+// parseHashFile parses the '<hash>  <filename>' format written by md5sum and friends. A '*' in front of the
+// filename marks binary mode and is dropped
+func parseHashFile(filename string, newHash func() hash.Hash) (*HashFile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+
+	size := newHash().Size() * 2
+	result := HashFile{
+		items:   make(map[string]string),
+		newHash: newHash,
+	}
+	for i, line := range strings.Split(checksumContent(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		m := hashLineRe.FindStringSubmatch(line)
+		if m == nil || len(m[1]) != size {
+			return nil, fmt.Errorf("%s:%d: expected '<hash> <filename>' but got %q", filename, i+1, line)
+		}
+
+		result.items[checksumName(m[2])] = m[1]
+	}
+
+	return &result, nil
+}
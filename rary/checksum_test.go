@@ -0,0 +1,77 @@
+package rary
+
+import (
+	"crypto/md5"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// windowsSFV is an SFV as written by tools on Windows, with a byte order mark, CRLF line endings and backslashes
+// for subdirectories
+const windowsSFV = "\ufeff; Generated by WIN-SFV32\r\nMovie.rar 0BADC0DE\r\nMovie.r00 DEADBEEF\r\nSubs\\subs.rar 12345678\r\n"
+
+func TestReadSFVWindows(t *testing.T) {
+	sfv, err := readSFV("windows.sfv", strings.NewReader(windowsSFV))
+	if err != nil {
+		t.Fatalf("readSFV: %v", err)
+	}
+
+	want := map[string]string{"Movie.rar": "0BADC0DE", "Movie.r00": "DEADBEEF", "Subs/subs.rar": "12345678"}
+	if !reflect.DeepEqual(sfv.items, want) {
+		t.Errorf("items = %q, want %q", sfv.items, want)
+	}
+}
+
+func TestParseHashFileWindows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "windows.md5")
+	content := "\ufeff# Generated on Windows\r\n" +
+		"0123456789abcdef0123456789abcdef *Movie.rar\r\n" +
+		"fedcba9876543210fedcba9876543210  Subs\\subs.rar\r\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := parseHashFile(path, md5.New)
+	if err != nil {
+		t.Fatalf("parseHashFile: %v", err)
+	}
+
+	want := map[string]string{"Movie.rar": "0123456789abcdef0123456789abcdef", "Subs/subs.rar": "fedcba9876543210fedcba9876543210"}
+	if !reflect.DeepEqual(file.items, want) {
+		t.Errorf("items = %q, want %q", file.items, want)
+	}
+}
+
+func TestChecksumErrorLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "leading blank lines", content: "\n\n; c\nbad line\n", want: "x.sfv:4:"},
+		{name: "byte order mark", content: "\ufeff\r\nbad line\r\n", want: "x.sfv:2:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readSFV("x.sfv", strings.NewReader(tt.content))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("readSFV = %v, want an error at %s", err, tt.want)
+			}
+
+			path := filepath.Join(t.TempDir(), "x.md5")
+			content := strings.ReplaceAll(tt.content, ";", "#")
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err = parseHashFile(path, md5.New)
+			want := path + strings.TrimPrefix(tt.want, "x.sfv")
+			if err == nil || !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("parseHashFile = %v, want an error at %s", err, want)
+			}
+		})
+	}
+}
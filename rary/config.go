@@ -0,0 +1,64 @@
+package rary
+
+const (
+	defaultUnrarBinary    = "unrar"
+	defaultSevenZipBinary = "7z"
+	defaultUnzipBinary    = "unzip"
+)
+
+// defaultFormats only enables rar so that the other tools are not required
+var defaultFormats = []string{"rar"}
+
+type Config struct {
+	// UnrarBinary is the name or path of the unrar binary. Defaults to unrar on the PATH
+	UnrarBinary string
+	// SevenZipBinary is the name or path of the 7z binary used for the 7z format. Defaults to 7z on the PATH
+	SevenZipBinary string
+	// UnzipBinary is the name or path of the unzip binary used for the zip format. Defaults to unzip on the PATH.
+	// Split zip sets are extracted with SevenZipBinary as unzip does not support them
+	UnzipBinary string
+	// Formats are the archive formats that are looked for, out of SupportedFormats. Defaults to only rar
+	Formats []string
+	// Archiver lists, tests and extracts archives of every format. Defaults to running the tool of the format
+	Archiver Archiver
+}
+
+var config Config
+
+// Configure sets the package wide configuration used by all archive tool invocations
+func Configure(c Config) {
+	config = c
+}
+
+func unrarBinary() string {
+	if config.UnrarBinary == "" {
+		return defaultUnrarBinary
+	}
+
+	return config.UnrarBinary
+}
+
+func sevenZipBinary() string {
+	if config.SevenZipBinary == "" {
+		return defaultSevenZipBinary
+	}
+
+	return config.SevenZipBinary
+}
+
+func unzipBinary() string {
+	if config.UnzipBinary == "" {
+		return defaultUnzipBinary
+	}
+
+	return config.UnzipBinary
+}
+
+// archiverFor returns the Archiver for the format of the volume
+func archiverFor(volume string) Archiver {
+	if config.Archiver != nil {
+		return config.Archiver
+	}
+
+	return formatOf(volume).archiver
+}
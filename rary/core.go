@@ -1,33 +1,105 @@
 package rary
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/burmudar/rar-hunter/rary/event"
 )
 
 type SFVFile struct {
 	items map[string]string
 }
 
+type fileEntry struct {
+	// rel is the slash separated path of the file relative to the root of the snapshot
+	rel     string
+	dir     bool
+	size    int64
+	modTime time.Time
+}
+
+type SnapshotOptions struct {
+	// CaseInsensitive makes FindName and the missing file check ignore case when comparing filenames
+	CaseInsensitive bool
+	// IncludeExts limits the snapshot to files with one of these extensions, eg. ".mkv". Empty means all files.
+	// Checksum files and archive volumes are kept regardless of IncludeExts and ExcludeExts
+	IncludeExts []string
+	// ExcludeExts leaves files with any of these extensions out of the snapshot, eg. ".nfo" or ".jpg"
+	ExcludeExts []string
+	// RelativePaths keys files by their slash separated path relative to the root, eg. "CD1/disc.rar", instead of
+	// their base name. This is needed for checksum files that list files in subdirectories
+	RelativePaths bool
+}
+
+func hasExt(exts []string, name string) bool {
+	ext := filepath.Ext(name)
+	for _, e := range exts {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keep reports whether a file passes the extension filters of the options. The files a set is found by always pass
+func (o SnapshotOptions) keep(name string) bool {
+	if hasExt(checksumExts(), name) || isVolume(name) {
+		return true
+	}
+	if len(o.IncludeExts) > 0 && !hasExt(o.IncludeExts, name) {
+		return false
+	}
+
+	return !hasExt(o.ExcludeExts, name)
+}
+
 type DirSnapshot struct {
 	root  string
-	files map[string]interface{}
+	files map[string]fileEntry
+	opts  SnapshotOptions
 }
 
 type Unrar struct {
 	filename string
 	wd       string
+	// checksums are the checksum files the set was checked against. When a directory holds several sets they belong
+	// to the last one so that they are only deleted once
+	checksums []string
+	// volumes are all the files of the set as returned by Volumes
+	volumes []string
+	// size is the total size of the volumes
+	size int64
+}
+
+// Size returns the total size of the files of the set as they were when it was found
+func (u *Unrar) Size() int64 {
+	return u.size
 }
 
 func (u *Unrar) Path() string {
 	return filepath.Join(u.wd, u.filename)
 }
 
+// WorkDir returns the directory the set was found in
+func (u *Unrar) WorkDir() string {
+	return u.wd
+}
+
 func (f *DirSnapshot) Find(filter func(item string) bool) []string {
 	files := []string{}
 	for file := range f.files {
@@ -40,45 +112,173 @@ func (f *DirSnapshot) Find(filter func(item string) bool) []string {
 	return files
 }
 
+// findInRoot returns the files matching filter that can belong to a set in the root, ie. its volumes and checksum
+// files. Unless the snapshot keys files by their relative path only the top level is searched, as a
+// 'Sample/movie-sample.rar' keyed by its base name would otherwise be taken for a volume in the root
+func (f *DirSnapshot) findInRoot(filter func(item string) bool) []string {
+	return f.Find(func(item string) bool {
+		if !f.opts.RelativePaths && strings.Contains(f.files[item].rel, "/") {
+			return false
+		}
+		return filter(item)
+	})
+}
+
 func (f *DirSnapshot) FindName(name string) []string {
+	if f.opts.CaseInsensitive {
+		return f.FindNameFold(name)
+	}
+
 	return f.Find(func(item string) bool {
 		return name == item
 	})
 }
 
+func (f *DirSnapshot) FindNameFold(name string) []string {
+	return f.Find(func(item string) bool {
+		return strings.EqualFold(name, item)
+	})
+}
+
+// Has reports whether the file is in the snapshot, ignoring case when the snapshot is case insensitive
+func (f *DirSnapshot) Has(name string) bool {
+	_, ok := f.resolve(name)
+	return ok
+}
+
+// resolve returns the name the file has in the snapshot, which differs from name in case when the snapshot is case
+// insensitive
+func (f *DirSnapshot) resolve(name string) (string, bool) {
+	if _, ok := f.files[name]; ok {
+		return name, true
+	}
+	if !f.opts.CaseInsensitive {
+		return "", false
+	}
+
+	names := f.FindNameFold(name)
+	if len(names) == 0 {
+		return "", false
+	}
+	sort.Strings(names)
+	return names[0], true
+}
+
 func (f *DirSnapshot) FindExt(ext string) []string {
+	return f.FindExts(ext)
+}
+
+// FindExts returns the files with any of the extensions in a single pass over the snapshot. Extensions are compared
+// ignoring case so that .RAR matches .rar, regardless of SnapshotOptions.CaseInsensitive which only applies to names
+func (f *DirSnapshot) FindExts(exts ...string) []string {
 	return f.Find(func(item string) bool {
-		fileExt := filepath.Ext(item)
-		return ext == fileExt
+		return hasExt(exts, item)
 	})
+}
+
+// FindGlob returns the files matching the filepath.Match pattern, eg. '*.part*.rar' or 'sample.*'
+func (f *DirSnapshot) FindGlob(pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	return f.Find(func(item string) bool {
+		ok, _ := filepath.Match(pattern, item)
+		return ok
+	}), nil
+}
+
+// Size returns the size of the file as it was when the snapshot was taken
+func (f *DirSnapshot) Size(file string) int64 {
+	return f.files[file].size
+}
 
+// ModTime returns the modification time of the file as it was when the snapshot was taken
+func (f *DirSnapshot) ModTime(file string) time.Time {
+	return f.files[file].modTime
 }
 
 func (f *DirSnapshot) Path(file string) string {
 	return filepath.Join(f.root, file)
 }
 
+// Hash returns a digest of the path, size and modification time of every file in the snapshot. It changes whenever
+// a file is added, removed or rewritten
+func (f *DirSnapshot) Hash() string {
+	entries := make([]fileEntry, 0, len(f.files))
+	for _, entry := range f.files {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].rel < entries[j].rel })
+
+	h := sha256.New()
+	for _, entry := range entries {
+		fmt.Fprintf(h, "%s\x00%t\x00%d\x00%d\n", entry.rel, entry.dir, entry.size, entry.modTime.UnixNano())
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Subset returns a snapshot of the same root that only holds the named files, eg. the files a Diff reports as added
+func (f *DirSnapshot) Subset(names []string) *DirSnapshot {
+	files := make(map[string]fileEntry, len(names))
+	for _, name := range names {
+		if entry, ok := f.files[name]; ok {
+			files[name] = entry
+		}
+	}
+
+	return &DirSnapshot{root: f.root, files: files, opts: f.opts}
+}
+
 func newSFVFile() *SFVFile {
 	return &SFVFile{
 		items: make(map[string]string),
 	}
 }
 
-func NewDirSnapshot(root string) (*DirSnapshot, error) {
+func NewDirSnapshot(root string, opts SnapshotOptions) (*DirSnapshot, error) {
 	list := DirSnapshot{
 		root:  root,
-		files: make(map[string]interface{}),
+		files: make(map[string]fileEntry),
+		opts:  opts,
 	}
-	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-		if root == path {
-			return nil
+
+	return &list, list.Refresh()
+}
+
+// Refresh re-walks the root of the snapshot and replaces the files it holds, keeping the root and options
+func (f *DirSnapshot) Refresh() error {
+	files := make(map[string]fileEntry)
+	err := filepath.WalkDir(f.root, func(path string, d fs.DirEntry, err error) error {
+		if f.root == path {
+			return err
 		}
 		name := strings.TrimSpace(filepath.Base(path))
-		list.files[name] = nil
+		if f.opts.RelativePaths {
+			rel, err := filepath.Rel(f.root, path)
+			if err != nil {
+				return nil
+			}
+			name = filepath.ToSlash(rel)
+		}
+		if !d.IsDir() && !f.opts.keep(name) {
+			return nil
+		}
+		entry := fileEntry{dir: d.IsDir()}
+		if rel, err := filepath.Rel(f.root, path); err == nil {
+			entry.rel = filepath.ToSlash(rel)
+		}
+		if info, err := d.Info(); err == nil {
+			entry.size = info.Size()
+			entry.modTime = info.ModTime()
+		}
+		files[name] = entry
 		return nil
 	})
+	f.files = files
 
-	return &list, nil
+	return err
 }
 
 func parseSFV(filename string) (*SFVFile, error) {
@@ -86,29 +286,61 @@ func parseSFV(filename string) (*SFVFile, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
 	}
+	defer f.Close()
+
+	return readSFV(filename, f)
+}
 
-	data, err := io.ReadAll(f)
+// ParseSFV parses an SFV from r, for use with FindUnrarableWith
+func ParseSFV(r io.Reader) (*SFVFile, error) {
+	return readSFV("sfv", r)
+}
+
+// readSFV parses an SFV from r, reporting errors against the line of the named file
+func readSFV(filename string, r io.Reader) (*SFVFile, error) {
+	data, err := io.ReadAll(r)
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
 
-	content := strings.TrimSpace(string(data))
-
+	// lines are trimmed one by one, trimming the content would shift the line numbers of errors
 	sfv := newSFVFile()
-	for _, line := range strings.Split(content, "\n") {
-		parts := strings.Split(line, " ")
+	for i, line := range strings.Split(checksumContent(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
 
-		sfv.items[parts[0]] = parts[1]
+		name, crc, err := parseSFVLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, i+1, err)
+		}
 
+		sfv.items[checksumName(name)] = crc
 	}
 
 	return sfv, nil
 }
 
-func anyMissing(sfv *SFVFile, dir *DirSnapshot) []string {
+var sfvLineRe = regexp.MustCompile(`^(.+?)\s+([[:xdigit:]]{8})$`)
+
+// parseSFVLine treats the last whitespace separated token as the crc and everything before it as the filename
+func parseSFVLine(line string) (string, string, error) {
+	m := sfvLineRe.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return "", "", fmt.Errorf("expected '<filename> <crc32>' but got %q", line)
+	}
+
+	return m[1], m[2], nil
+}
+
+// anyMissing returns the archive volumes listed in the checksum file that are not in the directory. Other entries,
+// such as the nfo or the payload the set extracts to, are not required for the set to be extracted
+func anyMissing(sfv ChecksumFile, dir *DirSnapshot) []string {
 	missing := []string{}
-	for sfvFile := range sfv.items {
-		if _, ok := dir.files[sfvFile]; !ok {
+	volumes, _ := splitChecksumFiles(sfv)
+	for _, sfvFile := range volumes {
+		if !dir.Has(sfvFile) {
 			missing = append(missing, sfvFile)
 		}
 	}
@@ -116,142 +348,408 @@ func anyMissing(sfv *SFVFile, dir *DirSnapshot) []string {
 	return missing
 }
 
-func findFirst[T any](list []T) (*T, error) {
-	if len(list) > 0 {
-		return &list[0], nil
-	}
+// listings caches the primary entry of each archive for the duration of a run. An entry is only used while the
+// modification time of the archive matches the one it was listed at
+var listings = struct {
+	sync.Mutex
+	entries map[string]listing
+}{entries: map[string]listing{}}
 
-	return nil, fmt.Errorf("no first because zero length")
+type listing struct {
+	modTime time.Time
+	name    string
 }
 
-func filenameFromRar(rarPath string) (string, error) {
-	cmd := exec.Command("unrar", []string{"lb", rarPath}...)
+// cachedPrimaryEntry returns the primaryEntry of the archive, listing it only once per modification time
+func cachedPrimaryEntry(ctx context.Context, rarPath string) (string, error) {
+	info, err := os.Stat(rarPath)
+	if err != nil {
+		return "", err
+	}
+
+	listings.Lock()
+	cached, ok := listings.entries[rarPath]
+	listings.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.name, nil
+	}
+
+	name, err := primaryEntry(ctx, rarPath)
+	if err != nil {
+		return "", err
+	}
+
+	listings.Lock()
+	listings.entries[rarPath] = listing{modTime: info.ModTime(), name: name}
+	listings.Unlock()
 
-	out, err := cmd.CombinedOutput()
+	return name, nil
+}
+
+// FindUnrarable locates and parses the checksum files of the directory and checks whether the sets it describes can
+// be extracted. There is a set per subdirectory holding volumes, see PrimaryArchives
+func FindUnrarable(ctx context.Context, dir *DirSnapshot) ([]*Unrar, error) {
+	sfv, checksums, err := findChecksumFile(dir)
 	if err != nil {
-		return "", fmt.Errorf("rar command failure: %w", err)
+		return nil, err
 	}
 
-	return strings.TrimSpace(string(out)), nil
+	return findUnrarable(ctx, dir, sfv, checksums)
+}
 
+// FindUnrarableWith is FindUnrarable for a checksum file that was already parsed. As the checksum file does not
+// come from the directory it is not treated as part of the set, so it is not deleted with the volumes
+func FindUnrarableWith(ctx context.Context, dir *DirSnapshot, sfv ChecksumFile) ([]*Unrar, error) {
+	return findUnrarable(ctx, dir, sfv, nil)
 }
 
-func FindUnrarable(dir *DirSnapshot) (*Unrar, error) {
-	result := Unrar{filename: "", wd: dir.root}
-	sfvFile := ""
-	if files := dir.FindExt(".sfv"); len(files) > 0 {
-		sfvFile = dir.Path(files[0])
-	} else {
-		return &result, fmt.Errorf("no .sfv files found in %s\n", dir.root)
+func findUnrarable(ctx context.Context, dir *DirSnapshot, sfv ChecksumFile, checksums []string) ([]*Unrar, error) {
+	if extracted(dir, sfv) {
+		return nil, fmt.Errorf("%w: %s", ErrAlreadyExtracted, dir.root)
 	}
 
-	sfv, err := parseSFV(sfvFile)
+	if err := checkCriteria(ctx, dir, sfv); err != nil {
+		return nil, err
+	}
+
+	primaries, err := PrimaryArchives(dir)
 	if err != nil {
-		return &result, err
+		return nil, err
 	}
 
-	if ok, criteria := MissingFiles(dir, sfv); ok {
-		return nil, criteria.Error()
+	sets := []*Unrar{}
+	for i, primary := range primaries {
+		set := &Unrar{filename: primary, wd: dir.root}
+		if i == len(primaries)-1 {
+			set.checksums = checksums
+		}
+		set.volumes = set.Volumes(dir, sfv)
+		for _, volume := range set.volumes {
+			set.size += dir.Size(volume)
+		}
+		sets = append(sets, set)
 	}
 
-	if ok, criteria := AlreadyUnrared(dir, sfv); ok {
-		return nil, criteria.Error()
+	return sets, nil
+}
+
+type ExtractMode int
+
+const (
+	// ExtractFlat extracts all files into a single directory with 'unrar e'
+	ExtractFlat ExtractMode = iota
+	// ExtractFullPath recreates the directory structure inside the archive with 'unrar x'
+	ExtractFullPath
+)
+
+func (m ExtractMode) command() string {
+	if m == ExtractFullPath {
+		return "x"
 	}
 
-	v, err := findFirst(dir.FindExt(".rar"))
-	if err != nil {
-		return nil, fmt.Errorf("faile to find .rar in %s", dir.root)
+	return "e"
+}
+
+// OverwritePolicy controls what unrar does when a file it is extracting already exists
+type OverwritePolicy int
+
+const (
+	// OverwriteSkip keeps the existing file, -o-
+	OverwriteSkip OverwritePolicy = iota
+	// OverwriteAll replaces the existing file, -o+
+	OverwriteAll
+	// OverwriteRename extracts the file under a new name, -or
+	OverwriteRename
+)
+
+func (p OverwritePolicy) flag() string {
+	switch p {
+	case OverwriteAll:
+		return "-o+"
+	case OverwriteRename:
+		return "-or"
+	default:
+		return "-o-"
 	}
+}
 
-	// dir.FindExt is a bit inconsistent. When do we need to find the relative path and when do we not ?
-	result.filename = *v
+// ErrNotStarted is the error of the targets that were not extracted because an earlier target failed with
+// DoAllOptions.FailFast set
+var ErrNotStarted = errors.New("not started after an earlier failure")
+
+type DoAllOptions struct {
+	// Concurrency is the maximum number of extractions that run at the same time. Defaults to runtime.NumCPU()
+	Concurrency int
+	// DryRun writes the command that would be run for each target to the writer without running it
+	DryRun bool
+	// TestBeforeExtract runs 'unrar t' on each target and skips the extraction when the test fails
+	TestBeforeExtract bool
+	// Notify receives the events published during extraction, such as event.ExtractProgress and event.ExtractDone.
+	// It is called from multiple goroutines
+	Notify func(ev any)
+	// DeleteAfterExtract removes the volumes of a set along with its checksum file once it has been extracted
+	// successfully. An event.VolumesDeleted is published listing the removed files
+	DeleteAfterExtract bool
+	// OutputDir is the directory extracted files are written to, in a subdirectory named after the directory of the
+	// archive. A relative OutputDir is relative to the current directory. When empty files are extracted next to the
+	// archive
+	OutputDir string
+	// ExtractMode selects between flat and full path extraction. Defaults to ExtractFlat
+	ExtractMode ExtractMode
+	// OverwritePolicy is passed to unrar so that it never prompts for existing files. Defaults to OverwriteSkip
+	OverwritePolicy OverwritePolicy
+	// Timeout is the maximum amount of time a single extraction may take before unrar is killed. Zero means no limit
+	Timeout time.Duration
+	// QuarantineDir is where the volumes of a set are moved to when testing or extracting it reports
+	// ErrCorruptArchive, in a subdirectory named after the directory of the set. An event.Quarantined is published
+	// listing the moved files. When empty corrupt sets are left in place
+	QuarantineDir string
+	// WarningPatterns are regular expressions for messages unrar writes to stderr that are benign, such as a corrupt
+	// header of a recovery record. Matching messages are kept in DoAllResult.Warnings and fail neither the
+	// extraction nor the test of TestBeforeExtract
+	WarningPatterns []string
+	// Stream copies the output of every extraction to the writer of DoAll as it is produced, each line prefixed with
+	// the name of the archive. The output is still kept in DoAllResult.Output
+	Stream bool
+	// FailFast stops starting new extractions once one has failed, the remaining targets report ErrNotStarted.
+	// Extractions that are already running are left to finish
+	FailFast bool
+
+	warnings []*regexp.Regexp
+}
+
+func (o DoAllOptions) destination(target *Unrar) string {
+	if o.OutputDir == "" {
+		return ""
+	}
 
-	return &result, err
+	return filepath.Join(o.OutputDir, filepath.Base(target.wd))
 }
 
-func pipeReader(cmd *exec.Cmd) (io.Reader, error) {
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
+func (o DoAllOptions) request(target *Unrar, warn func(message string), stdout, stderr io.Writer) ExtractRequest {
+	return ExtractRequest{
+		Archive:   target.filename,
+		Dir:       target.wd,
+		Dest:      o.destination(target),
+		Mode:      o.ExtractMode,
+		Overwrite: o.OverwritePolicy,
+		Notify:    o.Notify,
+		Warnings:  o.warnings,
+		Warn:      warn,
+		Stdout:    stdout,
+		Stderr:    stderr,
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, err
+}
+
+// DoAllResult is the outcome of extracting a single target
+type DoAllResult struct {
+	Archive  string
+	WorkDir  string
+	Output   string
+	Err      error
+	Duration time.Duration
+	// Size is the total size of the volumes of the set
+	Size int64
+	// Warnings are the messages that matched DoAllOptions.WarningPatterns
+	Warnings []string
+}
+
+// ResultsError formats the failed results into a single human readable error. Sets skipped with ErrLocked or
+// ErrNotStarted are not failures. It returns nil when all results succeeded
+func ResultsError(results []DoAllResult) error {
+	content := ""
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil && !errors.Is(r.Err, ErrLocked) && !errors.Is(r.Err, ErrNotStarted) {
+			content = content + fmt.Sprintf("[%s] did not complete successfully:  %s", r.Archive, r.Err) + "\n"
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("encountered %d errors\n%s\n", failed, content)
+	}
+	return nil
+}
+
+// quarantineCorrupt moves the set into the quarantine dir when err reports it as corrupt. It returns err along with
+// any error moving the files
+func quarantineCorrupt(target *Unrar, opts DoAllOptions, err error) error {
+	if !errors.Is(err, ErrCorruptArchive) || opts.QuarantineDir == "" {
+		return err
+	}
+
+	if qErr := quarantine(target, opts.QuarantineDir, opts.Notify); qErr != nil {
+		return fmt.Errorf("%w: %v", err, qErr)
 	}
-	return io.MultiReader(stdout, stderr), nil
 
+	return err
 }
 
-func DoAll(targets []*Unrar, w io.Writer) error {
-	rFn := func(src string, data []byte, err error) struct {
-		src  string
-		data string
-		err  error
-	} {
-		return struct {
-			src  string
-			data string
-			err  error
-		}{
-			src, "data: " + string(data), err,
+func extract(parent context.Context, target *Unrar, opts DoAllOptions, warn func(message string), stdout, stderr io.Writer) ([]byte, error) {
+	ctx := parent
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, opts.Timeout)
+		defer cancel()
+	}
+
+	if opts.TestBeforeExtract {
+		if err := archiverFor(target.filename).Test(ctx, target.Path(), opts.warnings); err != nil {
+			return nil, quarantineCorrupt(target, opts, err)
 		}
 	}
-	var resultCh chan struct {
-		src  string
-		data string
-		err  error
-	} = make(chan struct {
-		src  string
-		data string
-		err  error
-	})
-	var errors []error = []error{}
+
+	data, err := archiverFor(target.filename).Extract(ctx, opts.request(target, warn, stdout, stderr))
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("%w: %v", ctx.Err(), err)
+		if parent.Err() == nil {
+			err = fmt.Errorf("timed out after %s: %w", opts.Timeout, err)
+		}
+	}
+	if err == nil && opts.DeleteAfterExtract {
+		err = deleteVolumes(target, opts.Notify)
+	}
+
+	return data, quarantineCorrupt(target, opts, err)
+}
+
+// DoAll extracts all the targets and returns a result for each of them. Each set is extracted while holding a lock
+// on the directory it is extracted to, sets that another process holds the lock of are skipped with ErrLocked.
+// Cancelling ctx kills any unrar processes that are still running. The results are in the order of targets and the
+// returned error is their ResultsError
+func DoAll(ctx context.Context, targets []*Unrar, w io.Writer, opts DoAllOptions) ([]DoAllResult, error) {
+	// the tools run in the directory of the set, a relative OutputDir has to be resolved against ours
+	if opts.OutputDir != "" {
+		abs, err := filepath.Abs(opts.OutputDir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output dir %q: %w", opts.OutputDir, err)
+		}
+		opts.OutputDir = abs
+	}
+
+	if opts.DryRun {
+		for _, target := range targets {
+			c, ok := archiverFor(target.filename).(commandArchiver)
+			if !ok {
+				fmt.Fprintf(w, "Will extract: %s (in %s)\n", target.filename, target.wd)
+				continue
+			}
+			cmd := c.command(ctx, opts.request(target, nil, nil, nil))
+			fmt.Fprintf(w, "Will run: %s (in %s)\n", strings.Join(cmd.Args, " "), cmd.Dir)
+		}
+		return nil, nil
+	}
+
+	for _, pattern := range opts.WarningPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid warning pattern %q: %w", pattern, err)
+		}
+		opts.warnings = append(opts.warnings, re)
+	}
+
+	// the goroutines share w once output is streamed
+	if opts.Stream {
+		w = &syncWriter{w: w}
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	sem := make(chan struct{}, concurrency)
+
+	// failed is cancelled on the first failure with FailFast so that the targets still waiting are not started
+	failed, fail := context.WithCancel(context.Background())
+	defer fail()
+
+	// each goroutine stores its result at the index of its target
+	results := make([]DoAllResult, len(targets))
+	// wg also waits for the locks to be released, which happens after the result is stored
+	var wg sync.WaitGroup
 	for i := 0; i < len(targets); i++ {
-		target := targets[i]
-		fmt.Printf("unrar %s in %s\n", target.filename, target.wd)
+		i, target := i, targets[i]
+		fmt.Fprintf(w, "unrar %s in %s\n", target.filename, target.wd)
+		wg.Add(1)
 		go func() {
-			cmd := exec.Command("unrar", []string{"e", target.filename}...)
-			cmd.Dir = target.wd
-			// TODO: We have to read stdout and stdpipe seperately since errors are on stderr but command exits with 0
-			// TODO: We have to handle  the output and error reporting better
-			out, err := pipeReader(cmd)
-			if err != nil {
-				resultCh <- rFn(target.filename, nil, fmt.Errorf("stdout pipe: %w", err))
+			defer wg.Done()
+			result := DoAllResult{Archive: target.filename, WorkDir: target.wd, Size: target.size}
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				result.Err = ctx.Err()
+				results[i] = result
+				return
+			case <-failed.Done():
+				result.Err = ErrNotStarted
+				results[i] = result
 				return
 			}
-			if err := cmd.Start(); err != nil {
-				data, _ := io.ReadAll(out)
-				resultCh <- rFn(target.filename, data, err)
+			if failed.Err() != nil {
+				result.Err = ErrNotStarted
+				results[i] = result
 				return
 			}
-			data, err := io.ReadAll(out)
-			if err != nil && err != io.EOF {
-				resultCh <- rFn(target.filename, nil, fmt.Errorf("out read: %w", err))
+
+			// the lock is taken where the files are written, so that sets on a read-only source can be extracted
+			// into the OutputDir
+			lockTarget := target.wd
+			if dest := opts.destination(target); dest != "" {
+				if err := os.MkdirAll(dest, 0755); err != nil {
+					result.Err = fmt.Errorf("failed to create output dir: %w", err)
+					results[i] = result
+					return
+				}
+				lockTarget = dest
 			}
-			err = cmd.Wait()
-			resultCh <- rFn(target.filename, data, err)
-		}()
-	}
+			unlock, err := acquireDir(lockTarget)
+			if err != nil {
+				result.Err = err
+				if errors.Is(err, ErrLocked) && opts.Notify != nil {
+					opts.Notify(event.DirLocked{Archive: target.filename, WorkDir: target.wd})
+				}
+				results[i] = result
+				return
+			}
+			defer unlock()
 
-	count := len(targets)
-	for count > 0 {
-		select {
-		case r := <-resultCh:
-			{
-				if r.err != nil {
-					errors = append(errors, fmt.Errorf("[%s] did not complete successfully:  %s", r.src, r.err))
+			stdoutLines := &lineWriter{w: w, prefix: "[" + target.filename + "] "}
+			stderrLines := &lineWriter{w: w, prefix: "[" + target.filename + "] "}
+			var stdout, stderr io.Writer
+			if opts.Stream {
+				stdout, stderr = stdoutLines, stderrLines
+			}
+
+			start := time.Now()
+			data, err := extract(ctx, target, opts, func(message string) {
+				result.Warnings = append(result.Warnings, message)
+			}, stdout, stderr)
+			stdoutLines.Flush()
+			stderrLines.Flush()
+			result.Output = string(data)
+			result.Err = err
+			result.Duration = time.Since(start)
+			if err != nil && opts.FailFast {
+				fail()
+			}
+			if opts.Notify != nil {
+				if err != nil {
+					opts.Notify(event.ExtractFailed{Archive: target.filename, Err: err})
+				} else {
+					opts.Notify(event.ExtractDone{
+						Archive:  target.filename,
+						WorkDir:  target.wd,
+						Files:    extractedFiles(data),
+						Duration: result.Duration,
+					})
 				}
-				count--
 			}
-		}
+			results[i] = result
+		}()
 	}
+	wg.Wait()
 
-	if len(errors) > 0 {
-		content := ""
-		for _, e := range errors {
-			content = content + e.Error() + "\n"
-		}
-		return fmt.Errorf("encountered %d errors\n%s\n", len(targets), content)
-	}
-	return nil
+	return results, ResultsError(results)
 }
@@ -0,0 +1,613 @@
+package rary
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindUnrarable(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  SnapshotOptions
+		files []string
+		sfv   []string
+		// want are the primary followed by the volumes of each set
+		want [][]string
+		err  error
+	}{
+		{
+			name:  "complete set",
+			files: []string{"a.rar", "a.r00", "a.nfo"},
+			sfv:   []string{"a.rar", "a.r00"},
+			want:  [][]string{{"a.rar", "a.r00", "a.rar", "a.sfv"}},
+		},
+		{
+			name:  "set per disc",
+			opts:  SnapshotOptions{RelativePaths: true},
+			files: []string{"CD1/cd1.rar", "CD1/cd1.r00", "CD2/cd2.rar"},
+			sfv:   []string{"CD1/cd1.rar", "CD1/cd1.r00", "CD2/cd2.rar"},
+			want:  [][]string{{"CD1/cd1.rar", "CD1/cd1.r00", "CD1/cd1.rar"}, {"CD2/cd2.rar", "CD2/cd2.rar", "a.sfv"}},
+		},
+		{
+			name:  "include filter keeps the volumes and checksum file",
+			opts:  SnapshotOptions{IncludeExts: []string{".mkv"}},
+			files: []string{"a.rar", "a.r00", "a.nfo"},
+			sfv:   []string{"a.rar", "a.r00"},
+			want:  [][]string{{"a.rar", "a.r00", "a.rar", "a.sfv"}},
+		},
+		{
+			name:  "exclude filter keeps the volumes and checksum file",
+			opts:  SnapshotOptions{ExcludeExts: []string{".sfv", ".r00", ".nfo"}},
+			files: []string{"a.rar", "a.r00", "a.nfo"},
+			sfv:   []string{"a.rar", "a.r00"},
+			want:  [][]string{{"a.rar", "a.r00", "a.rar", "a.sfv"}},
+		},
+		{
+			name:  "sample in a subdirectory",
+			files: []string{"movie.part01.rar", "movie.part02.rar", "Sample/movie-sample.rar"},
+			sfv:   []string{"movie.part01.rar", "movie.part02.rar"},
+			want:  [][]string{{"movie.part01.rar", "movie.part01.rar", "movie.part02.rar", "a.sfv"}},
+		},
+		{
+			name:  "set in a subdirectory",
+			files: []string{"Season1/a.rar", "Season1/a.sfv"},
+			err:   ErrNoChecksumFile,
+		},
+		{
+			name:  "disc without its first volume",
+			opts:  SnapshotOptions{RelativePaths: true},
+			files: []string{"CD1/cd1.rar", "CD2/cd2.part02.rar"},
+			sfv:   []string{"CD1/cd1.rar", "CD2/cd2.part02.rar"},
+			err:   errAny,
+		},
+		{
+			name:  "missing volume",
+			files: []string{"a.rar", "a.r00"},
+			sfv:   []string{"a.rar", "a.r00", "a.r01"},
+			err:   &MissingFilesError{},
+		},
+		{
+			name:  "payload next to the volumes",
+			files: []string{"a.rar", "a.r00", "movie.mkv"},
+			sfv:   []string{"a.rar", "a.r00"},
+			err:   &CriteriaError{Name: "already-unrared"},
+		},
+		{
+			name:  "volumes deleted after extracting",
+			files: []string{"movie.mkv", "a.nfo"},
+			sfv:   []string{"a.rar", "a.r00"},
+			err:   ErrAlreadyExtracted,
+		},
+		{
+			name:  "unfinished downloads",
+			files: []string{"a.rar.part", "a.r00.!qB"},
+			sfv:   []string{"a.rar", "a.r00"},
+			err:   &MissingFilesError{},
+		},
+		{
+			name:  "payload listed in the checksum file",
+			files: []string{"movie.mkv", "a.nfo"},
+			sfv:   []string{"a.rar", "a.r00", "movie.mkv"},
+			err:   ErrAlreadyExtracted,
+		},
+		{
+			name:  "payload listed in the checksum file is missing",
+			files: []string{"other.mkv"},
+			sfv:   []string{"a.rar", "a.r00", "movie.mkv"},
+			err:   &MissingFilesError{},
+		},
+		{
+			name:  "no checksum file",
+			files: []string{"a.rar", "a.r00"},
+			err:   ErrNoChecksumFile,
+		},
+	}
+
+	useArchiver(t, &fakeArchiver{files: map[string][]string{"a.rar": {"movie.mkv"}, "cd1.rar": {"cd1.mkv"}, "cd2.rar": {"cd2.mkv"}, "movie.part01.rar": {"movie.mkv"}}})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, tt.files...)
+			if tt.sfv != nil {
+				writeSFV(t, root, "a.sfv", tt.sfv...)
+			}
+
+			dir, err := NewDirSnapshot(root, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sets, err := FindUnrarable(context.Background(), dir)
+			if tt.err != nil {
+				if !errorMatches(err, tt.err) {
+					t.Fatalf("FindUnrarable error = %v, want %T %v", err, tt.err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FindUnrarable: %v", err)
+			}
+			got := [][]string{}
+			for _, set := range sets {
+				got = append(got, append([]string{set.filename}, set.volumes...))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// errAny is the expected error of test cases that only check that there is an error
+var errAny = errors.New("any error")
+
+// errorMatches reports whether err is target, or of the same type when target is a pointer to an error struct. A
+// CriteriaError also has to be for the same criteria
+func errorMatches(err, target error) bool {
+	if target == errAny {
+		return err != nil
+	}
+
+	switch target := target.(type) {
+	case *MissingFilesError:
+		var e *MissingFilesError
+		return errors.As(err, &e)
+	case *CriteriaError:
+		var e *CriteriaError
+		return errors.As(err, &e) && e.Name == target.Name
+	}
+
+	return errors.Is(err, target)
+}
+
+func TestDoAll(t *testing.T) {
+	errExtract := errors.New("extract failed")
+	tests := []struct {
+		name    string
+		opts    DoAllOptions
+		failing string
+		// remaining are the files left in the directory of each set once DoAll returns
+		remaining []string
+	}{
+		{
+			name:      "extracts next to the volumes",
+			remaining: []string{"a.r00", "a.rar", "a.sfv", "movie.mkv"},
+		},
+		{
+			name:      "deletes the volumes after extracting",
+			opts:      DoAllOptions{DeleteAfterExtract: true},
+			remaining: []string{"movie.mkv"},
+		},
+		{
+			name:      "failed extraction keeps the volumes",
+			opts:      DoAllOptions{DeleteAfterExtract: true},
+			failing:   "a.rar",
+			remaining: []string{"a.r00", "a.rar", "a.sfv"},
+		},
+		{
+			name:      "corrupt set is not extracted",
+			opts:      DoAllOptions{TestBeforeExtract: true},
+			failing:   "a.rar",
+			remaining: []string{"a.r00", "a.rar", "a.sfv"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archiver := &fakeArchiver{files: map[string][]string{"a.rar": {"movie.mkv"}}}
+			if tt.failing != "" {
+				archiver.errs = map[string]error{tt.failing: errExtract}
+				archiver.corrupt = map[string]bool{tt.failing: true}
+			}
+			useArchiver(t, archiver)
+
+			targets := []*Unrar{}
+			dirs := []string{}
+			for i := 0; i < 2; i++ {
+				root := t.TempDir()
+				writeFiles(t, root, "a.rar", "a.r00")
+				writeSFV(t, root, "a.sfv", "a.rar", "a.r00")
+				dir, err := NewDirSnapshot(root, SnapshotOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				sets, err := FindUnrarable(context.Background(), dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				targets = append(targets, sets...)
+				dirs = append(dirs, root)
+			}
+			// the first set finishes last, its result still comes first
+			archiver.onExtract = func(req ExtractRequest) {
+				if req.Dir == dirs[0] {
+					time.Sleep(50 * time.Millisecond)
+				}
+			}
+
+			results, err := DoAll(context.Background(), targets, io.Discard, tt.opts)
+			if tt.failing == "" && err != nil {
+				t.Fatalf("DoAll: %v", err)
+			}
+			if tt.failing != "" && err == nil {
+				t.Fatal("DoAll did not report the failed sets")
+			}
+			if len(results) != len(targets) {
+				t.Fatalf("got %d results for %d targets", len(results), len(targets))
+			}
+			for i, result := range results {
+				if result.WorkDir != targets[i].wd {
+					t.Errorf("result %d is for %s, want %s", i, result.WorkDir, targets[i].wd)
+				}
+				if failed := result.Err != nil; failed != (tt.failing != "") {
+					t.Errorf("%s: err = %v", result.WorkDir, result.Err)
+				}
+			}
+
+			for _, root := range dirs {
+				entries, err := os.ReadDir(root)
+				if err != nil {
+					t.Fatal(err)
+				}
+				names := []string{}
+				for _, entry := range entries {
+					names = append(names, entry.Name())
+				}
+				if !reflect.DeepEqual(names, tt.remaining) {
+					t.Errorf("%s holds %v, want %v", filepath.Base(root), names, tt.remaining)
+				}
+			}
+		})
+	}
+}
+
+func TestDoAllSetPerDisc(t *testing.T) {
+	useArchiver(t, &fakeArchiver{files: map[string][]string{"cd1.rar": {"cd1.mkv"}, "cd2.rar": {"cd2.mkv"}}, delay: 50 * time.Millisecond})
+
+	root := t.TempDir()
+	writeFiles(t, root, "CD1/cd1.rar", "CD2/cd2.rar")
+	writeSFV(t, root, "a.sfv", "CD1/cd1.rar", "CD2/cd2.rar")
+	dir, err := NewDirSnapshot(root, SnapshotOptions{RelativePaths: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets, err := FindUnrarable(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both discs share the lock of the directory rather than the second being skipped as locked
+	if _, err := DoAll(context.Background(), sets, io.Discard, DoAllOptions{Concurrency: 2, DeleteAfterExtract: true}); err != nil {
+		t.Fatalf("DoAll: %v", err)
+	}
+	for _, name := range []string{"cd1.mkv", "cd2.mkv"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Errorf("%s was not extracted: %v", name, err)
+		}
+	}
+	for _, name := range []string{"CD1/cd1.rar", "CD2/cd2.rar", "a.sfv", lockName} {
+		if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+			t.Errorf("%s was not removed", name)
+		}
+	}
+}
+
+func TestReadSFV(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+	}{
+		{
+			name:    "single space",
+			content: "a.rar 0badc0de\n",
+			want:    map[string]string{"a.rar": "0badc0de"},
+		},
+		{
+			name:    "tab separated",
+			content: "a.rar\t0badc0de\na.r00\t\tDEADBEEF\n",
+			want:    map[string]string{"a.rar": "0badc0de", "a.r00": "DEADBEEF"},
+		},
+		{
+			name:    "multiple spaces",
+			content: "a.rar     0badc0de\n",
+			want:    map[string]string{"a.rar": "0badc0de"},
+		},
+		{
+			name:    "leading and trailing whitespace",
+			content: "   a.rar 0badc0de   \n\t a.r00 deadbeef\n",
+			want:    map[string]string{"a.rar": "0badc0de", "a.r00": "deadbeef"},
+		},
+		{
+			name:    "comments and blank lines",
+			content: "; generated by QuickSFV\n\na.rar 0badc0de\n\n",
+			want:    map[string]string{"a.rar": "0badc0de"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sfv, err := readSFV("test.sfv", strings.NewReader(tt.content))
+			if err != nil {
+				t.Fatalf("readSFV: %v", err)
+			}
+			if !reflect.DeepEqual(sfv.items, tt.want) {
+				t.Errorf("items = %v, want %v", sfv.items, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSFVLineErrors(t *testing.T) {
+	for _, line := range []string{"a.rar", "0badc0de", "a.rar 0badc0", "a.rar 0badc0dg"} {
+		if name, crc, err := parseSFVLine(line); err == nil {
+			t.Errorf("parseSFVLine(%q) = %q, %q, want an error", line, name, crc)
+		}
+	}
+}
+
+func TestParseSFVLineNames(t *testing.T) {
+	tests := []struct {
+		line string
+		name string
+		crc  string
+	}{
+		{"My Movie Part 1.rar ABCD1234", "My Movie Part 1.rar", "ABCD1234"},
+		{"My Movie Part 1.r00   ABCD1234", "My Movie Part 1.r00", "ABCD1234"},
+		{"movie.part01.rar ABCD1234", "movie.part01.rar", "ABCD1234"},
+		{"movie.rar\tABCD1234", "movie.rar", "ABCD1234"},
+	}
+
+	for _, tt := range tests {
+		name, crc, err := parseSFVLine(tt.line)
+		if err != nil {
+			t.Errorf("parseSFVLine(%q): %v", tt.line, err)
+			continue
+		}
+		if name != tt.name || crc != tt.crc {
+			t.Errorf("parseSFVLine(%q) = %q, %q, want %q, %q", tt.line, name, crc, tt.name, tt.crc)
+		}
+	}
+}
+
+func TestAnyMissingNamesWithSpaces(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "My Movie Part 1.rar", "My Movie Part 1.r00")
+	dir, err := NewDirSnapshot(root, SnapshotOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sfv, err := readSFV("test.sfv", strings.NewReader("My Movie Part 1.rar ABCD1234\nMy Movie Part 1.r00 ABCD1234\nMy Movie Part 1.r01 ABCD1234\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := anyMissing(sfv, dir); !reflect.DeepEqual(missing, []string{"My Movie Part 1.r01"}) {
+		t.Errorf("anyMissing = %v, want [My Movie Part 1.r01]", missing)
+	}
+}
+
+// writeScript creates an executable shell script in dir to stand in for an archive tool
+func writeScript(t *testing.T, dir, name, script string) string {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script as the archive tool")
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestDoAllTimeout(t *testing.T) {
+	// killing the script leaves the sleep behind holding its stdout and stderr open, like a wrapper script would
+	unrar := writeScript(t, t.TempDir(), "unrar", `case "$3" in slow.rar) sleep 10;; esac
+echo "All OK"
+`)
+	previous := config
+	Configure(Config{UnrarBinary: unrar})
+	t.Cleanup(func() { Configure(previous) })
+
+	slow := &Unrar{filename: "slow.rar", wd: t.TempDir()}
+	fast := &Unrar{filename: "fast.rar", wd: t.TempDir()}
+	start := time.Now()
+	results, err := DoAll(context.Background(), []*Unrar{slow, fast}, io.Discard, DoAllOptions{Timeout: 200 * time.Millisecond})
+	if err == nil {
+		t.Fatal("DoAll did not report the timed out set")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("DoAll took %s, the slow set was not killed", elapsed)
+	}
+
+	for _, result := range results {
+		switch result.Archive {
+		case "slow.rar":
+			if result.Err == nil || !strings.Contains(result.Err.Error(), "timed out after 200ms") {
+				t.Errorf("slow.rar: err = %v, want a timeout", result.Err)
+			}
+			if !errors.Is(result.Err, context.DeadlineExceeded) {
+				t.Errorf("slow.rar: err = %v, want context.DeadlineExceeded", result.Err)
+			}
+		case "fast.rar":
+			if result.Err != nil {
+				t.Errorf("fast.rar: %v", result.Err)
+			}
+		}
+	}
+}
+
+func TestMixedCaseNames(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "movie.rar", "Movie.R00", "MOVIE.nfo")
+	sfv, err := readSFV("test.sfv", strings.NewReader("Movie.RAR 0badc0de\nmovie.r00 0badc0de\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    SnapshotOptions
+		has     bool
+		fold    []string
+		missing []string
+	}{
+		{
+			name:    "case sensitive by default",
+			has:     false,
+			fold:    []string{"MOVIE.nfo"},
+			missing: []string{"Movie.RAR", "movie.r00"},
+		},
+		{
+			name:    "case insensitive",
+			opts:    SnapshotOptions{CaseInsensitive: true},
+			has:     true,
+			fold:    []string{"MOVIE.nfo"},
+			missing: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := NewDirSnapshot(root, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := dir.Has("MOVIE.RAR"); got != tt.has {
+				t.Errorf("Has(MOVIE.RAR) = %t, want %t", got, tt.has)
+			}
+			if !dir.Has("movie.rar") {
+				t.Error("Has(movie.rar) = false for the name on disk")
+			}
+			if got := dir.FindNameFold("movie.NFO"); !reflect.DeepEqual(got, tt.fold) {
+				t.Errorf("FindNameFold(movie.NFO) = %v, want %v", got, tt.fold)
+			}
+
+			missing := anyMissing(sfv, dir)
+			sort.Strings(missing)
+			if !reflect.DeepEqual(missing, tt.missing) {
+				t.Errorf("anyMissing = %v, want %v", missing, tt.missing)
+			}
+
+			// the checksum entries of another case are the same files, not more volumes
+			set := Unrar{filename: "movie.rar", wd: root}
+			if got, want := set.Volumes(dir, sfv), []string{"Movie.R00", "movie.rar"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Volumes = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFindExtsUppercase(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "MOVIE.RAR", "MOVIE.R00", "Movie.Sfv", "movie.nfo")
+	dir, err := NewDirSnapshot(root, SnapshotOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		exts []string
+		want []string
+	}{
+		{[]string{".rar"}, []string{"MOVIE.RAR"}},
+		{[]string{".RAR"}, []string{"MOVIE.RAR"}},
+		{[]string{".sfv", ".nfo"}, []string{"Movie.Sfv", "movie.nfo"}},
+		{[]string{".zip"}, []string{}},
+	}
+	for _, tt := range tests {
+		got := dir.FindExts(tt.exts...)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("FindExts(%v) = %v, want %v", tt.exts, got, tt.want)
+		}
+	}
+
+	primary, err := PrimaryArchive(dir)
+	if err != nil || primary != "MOVIE.RAR" {
+		t.Errorf("PrimaryArchive = %q, %v, want MOVIE.RAR", primary, err)
+	}
+}
+
+func TestDoAllLocksOutputDir(t *testing.T) {
+	source := t.TempDir()
+	out := t.TempDir()
+	writeFiles(t, source, "a.rar")
+	dest := filepath.Join(out, filepath.Base(source))
+
+	locked := map[string]bool{}
+	useArchiver(t, &fakeArchiver{
+		files: map[string][]string{"a.rar": {"movie.mkv"}},
+		onExtract: func(req ExtractRequest) {
+			for _, dir := range []string{req.Dir, req.Dest} {
+				_, err := os.Stat(filepath.Join(dir, lockName))
+				locked[dir] = err == nil
+			}
+		},
+	})
+
+	// the source is read-only, as it would be on a mounted share
+	if err := os.Chmod(source, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(source, 0755) })
+
+	target := &Unrar{filename: "a.rar", wd: source}
+	if _, err := DoAll(context.Background(), []*Unrar{target}, io.Discard, DoAllOptions{OutputDir: out}); err != nil {
+		t.Fatalf("DoAll: %v", err)
+	}
+	if locked[source] || !locked[dest] {
+		t.Errorf("locked %v while extracting, want only %s", locked, dest)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "movie.mkv")); err != nil {
+		t.Errorf("movie.mkv was not extracted into the output dir: %v", err)
+	}
+}
+
+func TestDoAllRelativeOutputDir(t *testing.T) {
+	cwd := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(cwd); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	source := t.TempDir()
+	writeFiles(t, source, "a.rar")
+	target := &Unrar{filename: "a.rar", wd: source}
+	dest := filepath.Join(cwd, "out", filepath.Base(source))
+
+	previous := config
+	Configure(Config{})
+	t.Cleanup(func() { Configure(previous) })
+	var out strings.Builder
+	if _, err := DoAll(context.Background(), []*Unrar{target}, &out, DoAllOptions{OutputDir: "out", DryRun: true}); err != nil {
+		t.Fatalf("DoAll: %v", err)
+	}
+	if !strings.Contains(out.String(), " "+dest+string(os.PathSeparator)) {
+		t.Errorf("dry run printed %q, want the destination %s", out.String(), dest)
+	}
+
+	archiver := &fakeArchiver{files: map[string][]string{"a.rar": {"movie.mkv"}}}
+	useArchiver(t, archiver)
+	if _, err := DoAll(context.Background(), []*Unrar{target}, io.Discard, DoAllOptions{OutputDir: "out"}); err != nil {
+		t.Fatalf("DoAll: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "movie.mkv")); err != nil {
+		t.Errorf("movie.mkv was not extracted into %s: %v", dest, err)
+	}
+}
@@ -1,8 +1,12 @@
 package rary
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 )
 
 type CriteriaResult[T any] struct {
@@ -18,16 +22,114 @@ func (c *CriteriaResult[T]) String() string {
 	} else {
 		value = c.StringFn(c.Value)
 	}
-	return fmt.Sprintf("Reason: %s\n", value)
+	value = strings.TrimRight(value, "\n")
+	if value == "" {
+		return fmt.Sprintf("Reason: %s\n", c.Reason)
+	}
+	return fmt.Sprintf("Reason: %s\n%s\n", c.Reason, value)
 }
 
 func (c *CriteriaResult[T]) Error() error {
-	return fmt.Errorf(c.String())
+	return errors.New(c.String())
+}
+
+// Criteria checks a directory against its checksum file. It returns true when the directory fails the criteria and
+// should be skipped, in which case the CriteriaResult explains why
+type Criteria[T any] func(ctx context.Context, dir *DirSnapshot, sfv ChecksumFile) (bool, CriteriaResult[T])
+
+// CriteriaError is returned by FindUnrarable when a directory fails one of the registered criteria
+type CriteriaError struct {
+	Name   string
+	Result CriteriaResult[any]
+}
+
+func (e *CriteriaError) Error() string {
+	return e.Result.String()
+}
+
+// MissingFilesError is returned by FindUnrarable when files listed in the checksum file are not in the directory
+type MissingFilesError struct {
+	Files []string
+	Err   *CriteriaError
+}
+
+func (e *MissingFilesError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *MissingFilesError) Unwrap() error {
+	return e.Err
+}
+
+type namedCriteria struct {
+	name     string
+	criteria Criteria[any]
+}
+
+var registry = struct {
+	sync.RWMutex
+	entries []namedCriteria
+}{
+	entries: []namedCriteria{
+		{"missing-files", AnyCriteria(MissingFiles)},
+		{"already-unrared", AnyCriteria(AlreadyUnrared)},
+		{"free-space", AnyCriteria(EnoughFreeSpace(DefaultSpaceFactor))},
+	},
+}
+
+// AnyCriteria adapts a typed Criteria so that it can be registered with RegisterCriteria
+func AnyCriteria[T any](c Criteria[T]) Criteria[any] {
+	return func(ctx context.Context, dir *DirSnapshot, sfv ChecksumFile) (bool, CriteriaResult[any]) {
+		ok, r := c(ctx, dir, sfv)
+		result := CriteriaResult[any]{Value: r.Value, Reason: r.Reason}
+		if r.StringFn != nil {
+			result.StringFn = func(v any) string { return r.StringFn(v.(T)) }
+		}
+
+		return ok, result
+	}
+}
+
+// RegisterCriteria adds a criteria that FindUnrarable checks after the ones already registered. Registering a
+// name that already exists replaces that criteria
+func RegisterCriteria(name string, c Criteria[any]) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	for i, entry := range registry.entries {
+		if entry.name == name {
+			registry.entries[i].criteria = c
+			return
+		}
+	}
+
+	registry.entries = append(registry.entries, namedCriteria{name, c})
+}
+
+func registeredCriteria() []namedCriteria {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	return append([]namedCriteria{}, registry.entries...)
 }
 
-type Criteria[T any] func(dir *DirSnapshot, sfv *SFVFile) (bool, CriteriaResult[T])
+// checkCriteria returns a CriteriaError for the first registered criteria the directory fails, wrapped in a
+// MissingFilesError when that is the missing-files criteria
+func checkCriteria(ctx context.Context, dir *DirSnapshot, sfv ChecksumFile) error {
+	for _, entry := range registeredCriteria() {
+		if ok, result := entry.criteria(ctx, dir, sfv); ok {
+			err := &CriteriaError{Name: entry.name, Result: result}
+			if missing, ok := result.Value.([]string); ok && entry.name == "missing-files" {
+				return &MissingFilesError{Files: missing, Err: err}
+			}
+			return err
+		}
+	}
 
-func MissingFiles(dir *DirSnapshot, sfv *SFVFile) (bool, CriteriaResult[[]string]) {
+	return nil
+}
+
+func MissingFiles(ctx context.Context, dir *DirSnapshot, sfv ChecksumFile) (bool, CriteriaResult[[]string]) {
 	var result CriteriaResult[[]string]
 	missing := anyMissing(sfv, dir)
 	if len(missing) > 0 {
@@ -41,27 +143,181 @@ func MissingFiles(dir *DirSnapshot, sfv *SFVFile) (bool, CriteriaResult[[]string
 	return len(result.Value) > 0, result
 }
 
-func AlreadyUnrared(dir *DirSnapshot, sfv *SFVFile) (bool, CriteriaResult[string]) {
+// AlreadyUnrared fails when the payload of every set in the directory is already next to its volumes
+func AlreadyUnrared(ctx context.Context, dir *DirSnapshot, sfv ChecksumFile) (bool, CriteriaResult[string]) {
 	var result CriteriaResult[string]
 	result.StringFn = func(v string) string { return v }
-	rar, err := findFirst(dir.FindExt(".rar"))
+	rars, err := PrimaryArchives(dir)
 	if err != nil {
-		result.Reason = fmt.Sprintf("error finding .rar files: %v", err)
+		result.Reason = fmt.Sprintf("error finding archives: %v", err)
 		return false, result
 	}
 
-	name, err := filenameFromRar(dir.Path(*rar))
+	existing := []string{}
+	for _, rar := range rars {
+		name, err := cachedPrimaryEntry(ctx, dir.Path(rar))
+		if err != nil {
+			result.Reason = "problem getting rar filename"
+			return false, result
+		}
+		names := dir.FindName(name)
+		if len(names) == 0 {
+			result.Value = name
+			return false, result
+		}
+		existing = append(existing, dir.Path(names[0]))
+	}
+
+	result.Value = strings.Join(existing, "\n")
+	result.Reason = "file already exists"
+	return true, result
+}
+
+// setVolumes returns the volumes of every set in the directory
+func setVolumes(dir *DirSnapshot) ([]string, error) {
+	primaries, err := PrimaryArchives(dir)
 	if err != nil {
-		result.Reason = "problem getting rar filename"
+		return nil, err
+	}
+
+	volumes := []string{}
+	for _, primary := range primaries {
+		volumes = append(volumes, volumesOf(dir, primary)...)
+	}
+
+	return volumes, nil
+}
+
+// DefaultSpaceFactor is the factor EnoughFreeSpace is registered with. Most releases are stored without compression
+// so the extracted payload is roughly the size of the volumes
+const DefaultSpaceFactor = 1.0
+
+// EnoughFreeSpace returns a criteria that fails when the filesystem of the directory has less free space than the
+// total size of the archive volumes multiplied by factor. Register it again under "free-space" to change the factor.
+// Criteria only see the set's directory, so when DoAllOptions.OutputDir is on another filesystem the space there is
+// not checked
+func EnoughFreeSpace(factor float64) Criteria[uint64] {
+	return func(ctx context.Context, dir *DirSnapshot, sfv ChecksumFile) (bool, CriteriaResult[uint64]) {
+		var result CriteriaResult[uint64]
+		volumes, err := setVolumes(dir)
+		if err != nil {
+			result.Reason = fmt.Sprintf("error finding archives: %v", err)
+			return false, result
+		}
+
+		var total int64
+		for _, volume := range volumes {
+			total += dir.Size(volume)
+		}
+
+		free, err := freeSpace(dir.root)
+		if err != nil {
+			result.Reason = fmt.Sprintf("problem getting free space: %v", err)
+			return false, result
+		}
+
+		required := uint64(float64(total) * factor)
+		result.Value = required
+		if required > free {
+			result.Reason = "not enough free space"
+			result.StringFn = func(v uint64) string {
+				return fmt.Sprintf("need %s but only %s is free in %s", FormatBytes(int64(v)), FormatBytes(int64(free)), dir.root)
+			}
+			return true, result
+		}
+
 		return false, result
 	}
-	names := dir.FindName(name)
-	if len(names) > 0 {
-		name = dir.Path(names[0])
-		result.Reason = "file already exists"
-		return true, result
+}
+
+// Settled returns a criteria that fails while any volume of the set is empty, or changes or disappears between the
+// snapshot and a second one taken settle later, which is the case while a download is still writing it. Only sets
+// with a volume modified within settle wait for the second snapshot, older volumes are taken as settled. It is not
+// registered by default
+func Settled(settle time.Duration) Criteria[[]string] {
+	return func(ctx context.Context, dir *DirSnapshot, sfv ChecksumFile) (bool, CriteriaResult[[]string]) {
+		var result CriteriaResult[[]string]
+		volumes, err := setVolumes(dir)
+		if err != nil {
+			result.Reason = fmt.Sprintf("error finding archives: %v", err)
+			return false, result
+		}
+
+		for _, volume := range volumes {
+			if dir.Size(volume) == 0 {
+				result.Value = append(result.Value, volume)
+			}
+		}
+		if len(result.Value) > 0 {
+			result.Reason = "volumes are still being written"
+			result.StringFn = func(v []string) string {
+				return fmt.Sprintf("Empty volumes:\n%s\n", strings.Join(v, "\n"))
+			}
+			return true, result
+		}
+
+		recent := []string{}
+		for _, volume := range volumes {
+			if time.Since(dir.ModTime(volume)) < settle {
+				recent = append(recent, volume)
+			}
+		}
+		if len(recent) == 0 {
+			return false, result
+		}
+
+		select {
+		case <-ctx.Done():
+			result.Reason = fmt.Sprintf("stopped waiting for volumes to settle: %v", ctx.Err())
+			return true, result
+		case <-time.After(settle):
+		}
+
+		later, err := NewDirSnapshot(dir.root, dir.opts)
+		if err != nil {
+			result.Reason = fmt.Sprintf("error taking second snapshot: %v", err)
+			return true, result
+		}
+		diff := Diff(dir.Subset(recent), later.Subset(recent))
+		result.Value = append(diff.Modified, diff.Removed...)
+		if len(result.Value) > 0 {
+			result.Reason = "volumes are still being written"
+			result.StringFn = func(v []string) string {
+				return fmt.Sprintf("Changed in the last %s:\n%s\n", settle, strings.Join(v, "\n"))
+			}
+			return true, result
+		}
+
+		return false, result
 	}
-	result.Value = name
-	return false, result
+}
+
+// MinSetSize returns a criteria that fails when the volumes of the set add up to less than min bytes, which filters
+// out subtitle packs and samples. It is not registered by default
+func MinSetSize(min int64) Criteria[int64] {
+	return func(ctx context.Context, dir *DirSnapshot, sfv ChecksumFile) (bool, CriteriaResult[int64]) {
+		var result CriteriaResult[int64]
+		primaries, err := PrimaryArchives(dir)
+		if err != nil {
+			result.Reason = fmt.Sprintf("error finding archives: %v", err)
+			return false, result
+		}
+
+		for _, primary := range primaries {
+			set := Unrar{filename: primary, wd: dir.root}
+			for _, volume := range set.Volumes(dir, sfv) {
+				result.Value += dir.Size(volume)
+			}
+		}
+
+		if result.Value < min {
+			result.Reason = "set is smaller than the minimum size"
+			result.StringFn = func(v int64) string {
+				return fmt.Sprintf("volumes total %s, the minimum is %s", FormatBytes(v), FormatBytes(min))
+			}
+			return true, result
+		}
 
+		return false, result
+	}
 }
@@ -0,0 +1,160 @@
+package rary
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckCriteria(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		sfv   []string
+		// criteria is the name of the criteria that fails, empty when the set passes all of them
+		criteria string
+	}{
+		{
+			name:     "missing files",
+			files:    []string{"a.rar"},
+			sfv:      []string{"a.rar", "a.r00"},
+			criteria: "missing-files",
+		},
+		{
+			name:     "already extracted",
+			files:    []string{"a.rar", "a.r00", "movie.mkv"},
+			sfv:      []string{"a.rar", "a.r00"},
+			criteria: "already-unrared",
+		},
+		{
+			name:  "clean",
+			files: []string{"a.rar", "a.r00", "a.nfo"},
+			sfv:   []string{"a.rar", "a.r00"},
+		},
+	}
+
+	useArchiver(t, &fakeArchiver{files: map[string][]string{"a.rar": {"movie.mkv"}}})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, tt.files...)
+			writeSFV(t, root, "a.sfv", tt.sfv...)
+			dir, err := NewDirSnapshot(root, SnapshotOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sfv, _, err := findChecksumFile(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = checkCriteria(context.Background(), dir, sfv)
+			if tt.criteria == "" {
+				if err != nil {
+					t.Errorf("checkCriteria: %v", err)
+				}
+				return
+			}
+
+			var criteriaErr *CriteriaError
+			if !errors.As(err, &criteriaErr) || criteriaErr.Name != tt.criteria {
+				t.Errorf("checkCriteria = %v, want %s to fail", err, tt.criteria)
+			}
+		})
+	}
+}
+
+func TestCriteriaResultPercentInFilename(t *testing.T) {
+	result := CriteriaResult[[]string]{
+		Value:  []string{"100% Pure.rar"},
+		Reason: "required files were missing",
+	}
+	result.StringFn = func(v []string) string { return "Missing files:\n" + strings.Join(v, "\n") }
+
+	want := "Reason: required files were missing\nMissing files:\n100% Pure.rar\n"
+	if got := result.String(); got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+	if got := result.Error().Error(); got != want {
+		t.Errorf("Error = %q, want %q", got, want)
+	}
+
+	plain := CriteriaResult[string]{Value: "/tmp/50%off.mkv", Reason: "file already exists"}
+	if got, want := plain.String(), "Reason: file already exists\n/tmp/50%off.mkv\n"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+}
+
+func TestSettled(t *testing.T) {
+	tests := []struct {
+		name string
+		// during is called while the criteria waits for the volumes to settle
+		during  func(root string)
+		empty   bool
+		old     bool
+		cancel  bool
+		skipped bool
+	}{
+		{name: "unchanged volumes"},
+		{name: "empty volume", empty: true, skipped: true},
+		{
+			name: "growing volume",
+			during: func(root string) {
+				os.WriteFile(filepath.Join(root, "a.r00"), []byte("more of a.r00"), 0644)
+			},
+			skipped: true,
+		},
+		{
+			name: "removed volume",
+			during: func(root string) {
+				os.Remove(filepath.Join(root, "a.r00"))
+			},
+			skipped: true,
+		},
+		{name: "cancelled", cancel: true, skipped: true},
+		// volumes last modified an hour ago are settled, a cancelled ctx would skip the set if it waited anyway
+		{name: "old volumes are not waited for", old: true, cancel: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, "a.rar", "a.r00")
+			if tt.empty {
+				if err := os.WriteFile(filepath.Join(root, "a.r00"), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.old {
+				hourAgo := time.Now().Add(-time.Hour)
+				for _, name := range []string{"a.rar", "a.r00"} {
+					if err := os.Chtimes(filepath.Join(root, name), hourAgo, hourAgo); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			dir, err := NewDirSnapshot(root, SnapshotOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			if during := tt.during; during != nil {
+				time.AfterFunc(10*time.Millisecond, func() { during(root) })
+			}
+
+			skipped, result := Settled(100*time.Millisecond)(ctx, dir, nil)
+			if skipped != tt.skipped {
+				t.Errorf("Settled = %t (%s), want %t", skipped, result.Reason, tt.skipped)
+			}
+		})
+	}
+}
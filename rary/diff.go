@@ -0,0 +1,42 @@
+package rary
+
+import "sort"
+
+// SnapshotDiff lists the files that differ between two snapshots of the same root. Names are the keys of the
+// snapshot, ie. base names or relative paths depending on SnapshotOptions.RelativePaths
+type SnapshotDiff struct {
+	Added    []string
+	Removed  []string
+	Modified []string
+}
+
+// Empty reports whether the snapshots hold the same files
+func (d SnapshotDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// Diff compares two snapshots of the same root. A file is modified when its size or modification time changed,
+// directories are only ever added or removed
+func Diff(old, new *DirSnapshot) SnapshotDiff {
+	diff := SnapshotDiff{Added: []string{}, Removed: []string{}, Modified: []string{}}
+	for name, entry := range new.files {
+		before, ok := old.files[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, name)
+		case !entry.dir && (entry.size != before.size || !entry.modTime.Equal(before.modTime)):
+			diff.Modified = append(diff.Modified, name)
+		}
+	}
+	for name := range old.files {
+		if _, ok := new.files[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Modified)
+
+	return diff
+}
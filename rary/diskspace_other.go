@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly
+
+package rary
+
+import "fmt"
+
+func freeSpace(path string) (uint64, error) {
+	return 0, fmt.Errorf("free space is not supported on this platform")
+}
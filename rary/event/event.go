@@ -0,0 +1,67 @@
+package event
+
+import "time"
+
+// ExtractProgress is published while unrar reports progress on a file it is extracting
+type ExtractProgress struct {
+	Archive string
+	File    string
+	Percent int
+}
+
+// ExtractDone is published when an archive was extracted successfully
+type ExtractDone struct {
+	Archive  string
+	WorkDir  string
+	Files    []string
+	Duration time.Duration
+}
+
+// ExtractFailed is published when extracting an archive failed
+type ExtractFailed struct {
+	Archive string
+	Err     error
+}
+
+// VolumesDeleted is published once the volumes of an extracted set have been removed
+type VolumesDeleted struct {
+	Archive string
+	WorkDir string
+	Files   []string
+}
+
+// Quarantined is published when the volumes of a corrupt set have been moved out of WorkDir into Dir
+type Quarantined struct {
+	Archive string
+	WorkDir string
+	Dir     string
+	Files   []string
+}
+
+// DirLocked is published when a set is skipped because another process holds the lock on its directory
+type DirLocked struct {
+	Archive string
+	WorkDir string
+}
+
+// DirFound is published for every directory discovered while scanning
+type DirFound struct {
+	Path string
+}
+
+// SymlinkLoop is published when a symlinked directory resolves to a directory that is already being walked
+type SymlinkLoop struct {
+	Path   string
+	Target string
+}
+
+// DirChanged is published when the contents of a watched directory have changed and settled
+type DirChanged struct {
+	Path string
+}
+
+// DirScanError is published when a directory could not be scanned
+type DirScanError struct {
+	Path string
+	Err  error
+}
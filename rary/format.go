@@ -0,0 +1,171 @@
+package rary
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var sevenZipVolumeRe = regexp.MustCompile(`(?i)\.7z(\.\d{3})?$`)
+var zipVolumeRe = regexp.MustCompile(`(?i)\.(zip|z\d{2})$`)
+
+// archiveFormat ties together how the volumes of a format are named and the Archiver that extracts them
+type archiveFormat struct {
+	name string
+	ext  string
+	// volume matches every file that can be a volume of the format
+	volume *regexp.Regexp
+	// primary returns the volume the tool is invoked on
+	primary func(dir *DirSnapshot) (string, error)
+	// volumes returns a regexp matching every volume in the same set as primary
+	volumes  func(primary string) *regexp.Regexp
+	archiver Archiver
+}
+
+var formats = []archiveFormat{
+	{"rar", ".rar", anyVolumeRe, PrimaryVolume, rarVolumeMatcher, unrarArchiver{}},
+	{"7z", ".7z", sevenZipVolumeRe, primarySevenZip, sevenZipVolumeMatcher, sevenZipArchiver{}},
+	{"zip", ".zip", zipVolumeRe, primaryZip, zipVolumeMatcher, unzipArchiver{}},
+}
+
+// SupportedFormats returns the names of the archive formats that can be enabled with Config.Formats
+func SupportedFormats() []string {
+	names := []string{}
+	for _, f := range formats {
+		names = append(names, f.name)
+	}
+
+	return names
+}
+
+func enabledFormats() []archiveFormat {
+	enabled := config.Formats
+	if len(enabled) == 0 {
+		enabled = defaultFormats
+	}
+
+	result := []archiveFormat{}
+	for _, f := range formats {
+		for _, name := range enabled {
+			if strings.EqualFold(f.name, name) {
+				result = append(result, f)
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// formatOf returns the format the volume belongs to, rar when it is not a volume of any format
+func formatOf(volume string) archiveFormat {
+	for _, f := range formats {
+		if f.volume.MatchString(volume) {
+			return f
+		}
+	}
+
+	return formats[0]
+}
+
+// isVolume reports whether name is a volume of any of the enabled formats
+func isVolume(name string) bool {
+	for _, f := range enabledFormats() {
+		if f.volume.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PrimaryArchive returns the volume to extract for the first enabled format that has volumes in the directory. When
+// the directory holds a set per subdirectory it is the primary of the first one, see PrimaryArchives
+func PrimaryArchive(dir *DirSnapshot) (string, error) {
+	primaries, err := PrimaryArchives(dir)
+	if err != nil {
+		return "", err
+	}
+
+	return primaries[0], nil
+}
+
+// PrimaryArchives returns a volume to extract per subdirectory holding volumes of the first enabled format that has
+// volumes in the directory, eg. 'CD1/disc.rar' and 'CD2/disc.rar' for a multi-disc release snapshot with
+// SnapshotOptions.RelativePaths. It fails when the primary of any of them cannot be found
+func PrimaryArchives(dir *DirSnapshot) ([]string, error) {
+	exts := []string{}
+	for _, f := range enabledFormats() {
+		volumes := dir.findInRoot(f.volume.MatchString)
+		if len(volumes) == 0 {
+			exts = append(exts, f.ext)
+			continue
+		}
+
+		groups := make(map[string][]string)
+		for _, volume := range volumes {
+			groups[path.Dir(volume)] = append(groups[path.Dir(volume)], volume)
+		}
+		subdirs := make([]string, 0, len(groups))
+		for subdir := range groups {
+			subdirs = append(subdirs, subdir)
+		}
+		sort.Strings(subdirs)
+
+		primaries := []string{}
+		for _, subdir := range subdirs {
+			primary, err := f.primary(dir.Subset(groups[subdir]))
+			if err != nil {
+				return nil, err
+			}
+			primaries = append(primaries, primary)
+		}
+		return primaries, nil
+	}
+
+	return nil, fmt.Errorf("no %s found in %s", strings.Join(exts, ", "), dir.root)
+}
+
+// primarySevenZip returns the plain .7z, or the first volume of a 'name.7z.001' split set
+func primarySevenZip(dir *DirSnapshot) (string, error) {
+	volumes := dir.findInRoot(sevenZipVolumeRe.MatchString)
+	sort.Strings(volumes)
+
+	for _, volume := range volumes {
+		if strings.EqualFold(filepath.Ext(volume), ".7z") {
+			return volume, nil
+		}
+	}
+	for _, volume := range volumes {
+		if strings.HasSuffix(volume, ".001") {
+			return volume, nil
+		}
+	}
+
+	return "", fmt.Errorf("found %d .7z.NNN volumes but no .7z.001 in %s", len(volumes), dir.root)
+}
+
+func sevenZipVolumeMatcher(primary string) *regexp.Regexp {
+	base := regexp.QuoteMeta(sevenZipVolumeRe.ReplaceAllString(primary, ""))
+	return regexp.MustCompile(`(?i)^` + base + `\.7z(\.\d{3})?$`)
+}
+
+// primaryZip returns the .zip, which for a 'name.z01', 'name.z02', 'name.zip' split set is the last volume
+func primaryZip(dir *DirSnapshot) (string, error) {
+	zips := dir.findInRoot(func(item string) bool { return hasExt([]string{".zip"}, item) })
+	sort.Strings(zips)
+	if len(zips) > 0 {
+		return zips[0], nil
+	}
+
+	volumes := dir.findInRoot(zipVolumeRe.MatchString)
+	return "", fmt.Errorf("found %d .zNN volumes but no .zip in %s", len(volumes), dir.root)
+}
+
+func zipVolumeMatcher(primary string) *regexp.Regexp {
+	base := regexp.QuoteMeta(zipVolumeRe.ReplaceAllString(primary, ""))
+	return regexp.MustCompile(`(?i)^` + base + `\.(zip|z\d{2})$`)
+}
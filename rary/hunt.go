@@ -0,0 +1,73 @@
+package rary
+
+import (
+	"context"
+	"sync"
+
+	"github.com/burmudar/rar-hunter/rary/event"
+	"github.com/burmudar/rar-hunter/rary/scanner"
+)
+
+// HuntOptions configures how Hunt discovers and checks directories
+type HuntOptions struct {
+	Scan     scanner.Options
+	Snapshot SnapshotOptions
+	// Workers is the number of directories checked concurrently, one when not set
+	Workers int
+	// Check replaces running FindUnrarable on a snapshot of every directory found, eg. to skip directories that
+	// were already handled. It is called from Workers goroutines at once and records the outcome in the report
+	Check func(ctx context.Context, report *ScanReport, found event.DirFound)
+}
+
+// Hunt scans the roots and runs FindUnrarable on every directory found. It returns the sets that can be extracted
+// and why the other directories were skipped, leaving it to the caller whether to pass the candidates to DoAll.
+// The error is only set when ctx was cancelled before the scan completed
+func Hunt(ctx context.Context, roots []string, opts HuntOptions) ([]*Unrar, []SkipInfo, error) {
+	report := ScanReport{}
+	err := HuntInto(ctx, &report, roots, opts)
+
+	return report.Candidates, report.Skipped, err
+}
+
+// HuntInto is Hunt recording the outcome of every directory in report, so that several hunts can add to one report
+func HuntInto(ctx context.Context, report *ScanReport, roots []string, opts HuntOptions) error {
+	check := opts.Check
+	if check == nil {
+		check = func(ctx context.Context, report *ScanReport, found event.DirFound) {
+			dir, err := NewDirSnapshot(found.Path, opts.Snapshot)
+			if err != nil {
+				report.Add(found.Path, nil, err)
+				return
+			}
+
+			sets, err := FindUnrarable(ctx, dir)
+			report.Add(found.Path, sets, err)
+		}
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	found := make(chan event.DirFound)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range found {
+				check(ctx, report, dir)
+			}
+		}()
+	}
+
+	for _, root := range roots {
+		for dir := range scanner.Scan(ctx, root, opts.Scan) {
+			found <- dir
+		}
+	}
+	close(found)
+	wg.Wait()
+
+	return ctx.Err()
+}
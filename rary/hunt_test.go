@@ -0,0 +1,69 @@
+package rary
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/burmudar/rar-hunter/rary/event"
+)
+
+func TestHunt(t *testing.T) {
+	useArchiver(t, &fakeArchiver{files: map[string][]string{"a.rar": {"a.mkv"}, "b.rar": {"b.mkv"}}})
+	root := t.TempDir()
+	writeFiles(t, root, "a/a.rar", "b/b.rar", "empty/")
+	writeSFV(t, filepath.Join(root, "a"), "a.sfv", "a.rar")
+	writeSFV(t, filepath.Join(root, "b"), "b.sfv", "b.rar")
+
+	sets, skipped, err := Hunt(context.Background(), []string{root}, HuntOptions{Workers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := []string{}
+	for _, set := range sets {
+		found = append(found, set.WorkDir())
+	}
+	sort.Strings(found)
+	if want := []string{filepath.Join(root, "a"), filepath.Join(root, "b")}; !reflect.DeepEqual(found, want) {
+		t.Errorf("sets in %v, want %v", found, want)
+	}
+	paths := []string{}
+	for _, skip := range skipped {
+		paths = append(paths, skip.Path)
+	}
+	sort.Strings(paths)
+	if want := []string{root, filepath.Join(root, "empty")}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("skipped %v, want %v", paths, want)
+	}
+}
+
+func TestHuntIntoCheck(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "a/", "b/")
+
+	var mu sync.Mutex
+	checked := []string{}
+	report := ScanReport{}
+	err := HuntInto(context.Background(), &report, []string{root}, HuntOptions{
+		Workers: 2,
+		Check: func(ctx context.Context, report *ScanReport, found event.DirFound) {
+			mu.Lock()
+			checked = append(checked, found.Path)
+			mu.Unlock()
+			report.Add(found.Path, nil, ErrNoChecksumFile)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(checked)
+	want := []string{root, filepath.Join(root, "a"), filepath.Join(root, "b")}
+	if !reflect.DeepEqual(checked, want) || report.Scanned != len(want) {
+		t.Errorf("checked %d directories %v, want %v", report.Scanned, checked, want)
+	}
+}
@@ -0,0 +1,53 @@
+package rary
+
+import (
+	"errors"
+	"sync"
+)
+
+// lockName is the file created in a directory while its set is being extracted
+const lockName = ".rar-hunter.lock"
+
+// ErrLocked is the error of a DoAllResult for a set that another process was already extracting
+var ErrLocked = errors.New("directory is locked by another process")
+
+// held are the directory locks this process holds, counted per set so that the sets of a multi-disc release share
+// the lock of their directory
+var held = struct {
+	sync.Mutex
+	dirs map[string]*heldLock
+}{dirs: map[string]*heldLock{}}
+
+type heldLock struct {
+	sets   int
+	unlock func()
+}
+
+// acquireDir takes the lock of dir unless this process already holds it. The returned func releases it once every
+// set that acquired it has released it
+func acquireDir(dir string) (func(), error) {
+	held.Lock()
+	defer held.Unlock()
+
+	lock, ok := held.dirs[dir]
+	if !ok {
+		unlock, err := lockDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		lock = &heldLock{unlock: unlock}
+		held.dirs[dir] = lock
+	}
+	lock.sets++
+
+	return func() {
+		held.Lock()
+		defer held.Unlock()
+
+		lock.sets--
+		if lock.sets == 0 {
+			delete(held.dirs, dir)
+			lock.unlock()
+		}
+	}, nil
+}
@@ -0,0 +1,27 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !netbsd && !openbsd
+
+package rary
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// lockDir creates the lock file of dir exclusively. A lock file left behind by a process that died has to be
+// removed by hand
+func lockDir(dir string) (func(), error) {
+	path := filepath.Join(dir, lockName)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf("%w: %s", ErrLocked, dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock file: %w", err)
+	}
+	f.Close()
+
+	return func() { os.Remove(path) }, nil
+}
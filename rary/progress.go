@@ -0,0 +1,93 @@
+package rary
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/burmudar/rar-hunter/rary/event"
+)
+
+var percentRe = regexp.MustCompile(`(\d{1,3})%$`)
+
+// splitProgress splits unrar output on newlines, carriage returns and the backspaces unrar uses to redraw the
+// percentage in place
+func splitProgress(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\n\r\b"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+// scanProgress reads unrar output from r and calls notify with an event.ExtractProgress whenever a percentage
+// is printed for a file
+func scanProgress(r io.Reader, archive string, notify func(ev any)) error {
+	if notify == nil {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Split(splitProgress)
+	file := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "Extracting from ") {
+			continue
+		}
+
+		if name, ok := progressFile(line); ok {
+			file = name
+		}
+
+		if m := percentRe.FindStringSubmatch(line); m != nil && file != "" {
+			percent, _ := strconv.Atoi(m[1])
+			notify(event.ExtractProgress{Archive: archive, File: file, Percent: percent})
+		}
+	}
+
+	return scanner.Err()
+}
+
+// progressFile extracts the filename from 'Extracting  <file>  5%' and the '...  <file>' continuation lines unrar
+// prints when a file spans multiple volumes
+func progressFile(line string) (string, bool) {
+	for _, prefix := range []string{"Extracting ", "..."} {
+		if strings.HasPrefix(line, prefix) {
+			name := strings.TrimSpace(strings.TrimPrefix(line, prefix))
+			name = strings.TrimSpace(percentRe.ReplaceAllString(name, ""))
+			name = strings.TrimSpace(strings.TrimSuffix(name, "OK"))
+			return name, name != ""
+		}
+	}
+
+	return "", false
+}
+
+// extractedFiles returns the files unrar reported extracting in its output, in the order they were extracted
+func extractedFiles(output []byte) []string {
+	files := []string{}
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Split(splitProgress)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "Extracting from ") {
+			continue
+		}
+
+		if name, ok := progressFile(line); ok && !seen[name] {
+			seen[name] = true
+			files = append(files, name)
+		}
+	}
+
+	return files
+}
@@ -0,0 +1,172 @@
+package rary
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// SkipInfo describes why a directory was not considered for extraction
+type SkipInfo struct {
+	Path     string
+	Criteria string
+	Reason   string
+	// Missing lists the files of the checksum file that were not found when the missing-files criteria failed
+	Missing []string
+	Err     error
+}
+
+func NewSkipInfo(path string, err error) SkipInfo {
+	info := SkipInfo{Path: path, Err: err}
+	var criteriaErr *CriteriaError
+	var missingErr *MissingFilesError
+	if errors.As(err, &missingErr) {
+		info.Missing = missingErr.Files
+	}
+	switch {
+	case errors.As(err, &criteriaErr):
+		info.Criteria = criteriaErr.Name
+		info.Reason = criteriaErr.Result.Reason
+	case errors.Is(err, ErrNoChecksumFile):
+		info.Reason = ErrNoChecksumFile.Error()
+	case errors.Is(err, ErrAlreadyExtracted):
+		info.Reason = ErrAlreadyExtracted.Error()
+	default:
+		info.Reason = strings.TrimSpace(err.Error())
+	}
+
+	return info
+}
+
+// ScanReport collects what happened to every directory during a run
+type ScanReport struct {
+	mu         sync.Mutex
+	Scanned    int
+	Candidates []*Unrar
+	Skipped    []SkipInfo
+	Results    []DoAllResult
+	// DryRun is set when the candidates were only printed, DoAll returns no results for a dry run
+	DryRun bool
+}
+
+// Decision is what happened to a single directory, in a form suitable for encoding as JSON
+type Decision struct {
+	Path    string   `json:"path"`
+	Action  string   `json:"action"`
+	Reason  string   `json:"reason,omitempty"`
+	Archive string   `json:"archive,omitempty"`
+	Missing []string `json:"missing,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	DryRun  bool     `json:"dry_run,omitempty"`
+}
+
+// Decisions returns a Decision for every skipped directory followed by one for every extraction, or for every
+// candidate of a dry run
+func (r *ScanReport) Decisions() []Decision {
+	decisions := []Decision{}
+	for _, skip := range r.Skipped {
+		decisions = append(decisions, Decision{Path: skip.Path, Action: "skip", Reason: skip.Reason, Missing: skip.Missing})
+	}
+
+	if r.DryRun {
+		for _, candidate := range r.Candidates {
+			decisions = append(decisions, Decision{Path: candidate.wd, Action: "extract", Archive: candidate.filename, DryRun: true})
+		}
+	}
+
+	for _, result := range r.Results {
+		d := Decision{Path: result.WorkDir, Action: "extract", Archive: result.Archive}
+		if errors.Is(result.Err, ErrLocked) || errors.Is(result.Err, ErrNotStarted) {
+			d.Action = "skip"
+			d.Reason = result.Err.Error()
+		} else if result.Err != nil {
+			d.Error = result.Err.Error()
+		}
+		decisions = append(decisions, d)
+	}
+
+	return decisions
+}
+
+// Add records the outcome of FindUnrarable for the directory at path. It is safe to call from multiple goroutines
+func (r *ScanReport) Add(path string, sets []*Unrar, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Scanned++
+	if err != nil {
+		r.Skipped = append(r.Skipped, NewSkipInfo(path, err))
+		return
+	}
+
+	r.Candidates = append(r.Candidates, sets...)
+}
+
+func (r *ScanReport) skipReasons() ([]string, map[string]int) {
+	counts := make(map[string]int)
+	for _, skip := range r.Skipped {
+		counts[skip.Reason]++
+	}
+
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		return counts[reasons[i]] > counts[reasons[j]]
+	})
+
+	return reasons, counts
+}
+
+// Render writes the report as a set of tables. Only directories that failed a criteria are listed individually,
+// the rest are summarised by reason
+func (r *ScanReport) Render(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "scanned\t%d\n", r.Scanned)
+	fmt.Fprintf(tw, "candidates\t%d\n", len(r.Candidates))
+	fmt.Fprintf(tw, "skipped\t%d\n", len(r.Skipped))
+
+	reasons, counts := r.skipReasons()
+	if len(reasons) > 0 {
+		fmt.Fprintf(tw, "\nREASON\tCOUNT\n")
+		for _, reason := range reasons {
+			fmt.Fprintf(tw, "%s\t%d\n", reason, counts[reason])
+		}
+	}
+
+	header := false
+	for _, skip := range r.Skipped {
+		if skip.Criteria == "" {
+			continue
+		}
+		if !header {
+			fmt.Fprintf(tw, "\nDIRECTORY\tCRITERIA\tREASON\n")
+			header = true
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", skip.Path, skip.Criteria, skip.Reason)
+	}
+
+	if len(r.Results) > 0 {
+		fmt.Fprintf(tw, "\nARCHIVE\tDIRECTORY\tSIZE\tDURATION\tSTATUS\n")
+		for _, result := range r.Results {
+			status := "ok"
+			if errors.Is(result.Err, ErrLocked) {
+				status = "locked"
+			} else if errors.Is(result.Err, ErrNotStarted) {
+				status = "not started"
+			} else if result.Err != nil {
+				status = "failed"
+			} else if len(result.Warnings) > 0 {
+				status = fmt.Sprintf("ok, %d warnings", len(result.Warnings))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", result.Archive, result.WorkDir, FormatBytes(result.Size), formatDuration(result.Duration), status)
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,22 @@
+//go:build windows || plan9
+
+package scanner
+
+import (
+	"path/filepath"
+)
+
+type fileID struct {
+	path string
+}
+
+// dirID identifies a directory by the path it resolves to since there is no device and inode to use
+func dirID(path string) (fileID, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fileID{}, err
+	}
+
+	abs, err := filepath.Abs(resolved)
+	return fileID{path: abs}, err
+}
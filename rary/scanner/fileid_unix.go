@@ -0,0 +1,29 @@
+//go:build !windows && !plan9
+
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// dirID identifies a directory by the device and inode it resolves to
+func dirID(path string) (fileID, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileID{}, err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, fmt.Errorf("no device and inode available for %s", path)
+	}
+
+	return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, nil
+}
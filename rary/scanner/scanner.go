@@ -0,0 +1,154 @@
+package scanner
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/burmudar/rar-hunter/rary/event"
+)
+
+// DefaultExclude are the directories release groups ship alongside the main feature that should not be extracted
+var DefaultExclude = []string{"sample", "proof", "subs"}
+
+type Options struct {
+	// FollowSymlinks descends into symlinked directories. Directories that resolve to one already being walked are
+	// skipped and reported as an event.SymlinkLoop
+	FollowSymlinks bool
+	// MaxDepth is the number of levels below the start directory the walk descends into. Zero means unlimited
+	MaxDepth int
+	// Exclude are filepath.Match patterns for directory names that are skipped along with everything below them.
+	// Patterns are matched case insensitively
+	Exclude []string
+	// Newer skips directories last modified before it when set. Directories below them are still walked, since the
+	// modification time of a directory only changes when its own entries do
+	Newer time.Time
+	// Notify receives the events published while scanning other than event.DirFound, such as event.DirScanError for
+	// directories that can't be read
+	Notify func(ev any)
+}
+
+type walker struct {
+	ctx       context.Context
+	opts      Options
+	found     chan<- event.DirFound
+	visited   map[fileID]struct{}
+	ancestors map[fileID]string
+}
+
+func (w *walker) notify(ev any) {
+	if w.opts.Notify != nil {
+		w.opts.Notify(ev)
+	}
+}
+
+func (w *walker) walk(path string, depth int) error {
+	if w.opts.FollowSymlinks {
+		id, err := dirID(path)
+		if err != nil {
+			w.notify(event.DirScanError{Path: path, Err: err})
+			return nil
+		}
+
+		if target, ok := w.ancestors[id]; ok {
+			w.notify(event.SymlinkLoop{Path: path, Target: target})
+			return nil
+		}
+		if _, ok := w.visited[id]; ok {
+			return nil
+		}
+
+		w.visited[id] = struct{}{}
+		w.ancestors[id] = path
+		defer delete(w.ancestors, id)
+	}
+
+	if w.newer(path) {
+		select {
+		case w.found <- event.DirFound{Path: path}:
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		}
+	}
+
+	if w.opts.MaxDepth > 0 && depth >= w.opts.MaxDepth {
+		return nil
+	}
+
+	// a directory that can't be read, eg. permission denied or removed while scanning, is reported and skipped
+	// without failing the rest of the walk
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		w.notify(event.DirScanError{Path: path, Err: err})
+		return nil
+	}
+
+	for _, entry := range entries {
+		child := filepath.Join(path, entry.Name())
+		if !entry.IsDir() && !(w.opts.FollowSymlinks && isDirLink(child, entry)) {
+			continue
+		}
+		if excluded(w.opts.Exclude, entry.Name()) {
+			continue
+		}
+
+		if err := w.walk(child, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newer reports whether the directory was modified after Options.Newer
+func (w *walker) newer(path string) bool {
+	if w.opts.Newer.IsZero() {
+		return true
+	}
+
+	info, err := os.Stat(path)
+	return err == nil && info.ModTime().After(w.opts.Newer)
+}
+
+// excluded reports whether the directory name matches any of the patterns, ignoring case
+func excluded(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isDirLink(path string, entry fs.DirEntry) bool {
+	if entry.Type()&fs.ModeSymlink == 0 {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// Scan walks dir in the background and sends every directory it finds, including dir itself, on the returned
+// channel. The channel is closed once the walk completes or ctx is cancelled
+func Scan(ctx context.Context, dir string, opts Options) <-chan event.DirFound {
+	found := make(chan event.DirFound)
+	go func() {
+		defer close(found)
+		w := walker{
+			ctx:       ctx,
+			opts:      opts,
+			found:     found,
+			visited:   make(map[fileID]struct{}),
+			ancestors: make(map[fileID]string),
+		}
+		w.walk(dir, 0)
+	}()
+
+	return found
+}
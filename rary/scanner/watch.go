@@ -0,0 +1,116 @@
+package scanner
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/burmudar/rar-hunter/rary/event"
+	"github.com/fsnotify/fsnotify"
+)
+
+const defaultDebounce = 5 * time.Second
+
+type WatchOptions struct {
+	// Debounce is how long a directory has to go without changes before it is reported. This gives downloads time
+	// to finish writing all the volumes of a set. Defaults to 5s
+	Debounce time.Duration
+	// Exclude are directory name patterns that are not watched, as in Options
+	Exclude []string
+	// Notify receives an event.DirScanError for errors reported by the watcher
+	Notify func(ev any)
+}
+
+func watchTree(watcher *fsnotify.Watcher, root string, exclude []string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			if path != root && excluded(exclude, d.Name()) {
+				return filepath.SkipDir
+			}
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// Watch watches root and all of its subdirectories and sends an event.DirChanged for a directory once its contents
+// have stopped changing for the debounce period. The channel is closed when ctx is cancelled
+func Watch(ctx context.Context, root string, opts WatchOptions) (<-chan event.DirChanged, error) {
+	debounce := opts.Debounce
+	if debounce <= 0 {
+		debounce = defaultDebounce
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := watchTree(watcher, root, opts.Exclude); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	changed := make(chan event.DirChanged)
+	go func() {
+		defer close(changed)
+		defer watcher.Close()
+
+		ticker := time.NewTicker(debounce / 2)
+		defer ticker.Stop()
+
+		pending := make(map[string]time.Time)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if ev.Op&fsnotify.Create != 0 {
+					if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+						if excluded(opts.Exclude, info.Name()) {
+							continue
+						}
+						watchTree(watcher, ev.Name, opts.Exclude)
+						pending[ev.Name] = time.Now()
+					}
+				}
+				pending[filepath.Dir(ev.Name)] = time.Now()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				if opts.Notify != nil {
+					opts.Notify(event.DirScanError{Path: root, Err: err})
+				}
+			case now := <-ticker.C:
+				for dir, last := range pending {
+					if now.Sub(last) < debounce {
+						continue
+					}
+
+					delete(pending, dir)
+					select {
+					case changed <- event.DirChanged{Path: dir}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return changed, nil
+}
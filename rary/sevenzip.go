@@ -0,0 +1,80 @@
+package rary
+
+import (
+	"context"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// sevenZipArchiver is the Archiver that runs 7z for the 7z format
+type sevenZipArchiver struct{}
+
+func (sevenZipArchiver) List(ctx context.Context, path string) ([]string, error) {
+	lines, err := listLines(ctx, func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, sevenZipBinary(), []string{"l", "-ba", "-slt", path}...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// -slt prints a block of 'Key = Value' lines per entry, starting with its Path. Directories are marked by their
+	// Folder or Attributes
+	names := []string{}
+	dirs := make(map[int]bool)
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch {
+		case key == "Path":
+			names = append(names, value)
+		case len(names) == 0:
+		case key == "Folder" && value == "+", key == "Attributes" && strings.HasPrefix(value, "D"):
+			dirs[len(names)-1] = true
+		}
+	}
+
+	files := []string{}
+	for i, name := range names {
+		if !dirs[i] {
+			files = append(files, name)
+		}
+	}
+
+	return files, nil
+}
+
+func sevenZipOverwrite(p OverwritePolicy) string {
+	switch p {
+	case OverwriteAll:
+		return "-aoa"
+	case OverwriteRename:
+		return "-aou"
+	default:
+		return "-aos"
+	}
+}
+
+func (sevenZipArchiver) command(ctx context.Context, req ExtractRequest) *exec.Cmd {
+	args := []string{req.Mode.command(), "-y", sevenZipOverwrite(req.Overwrite)}
+	if req.Dest != "" {
+		args = append(args, "-o"+req.Dest)
+	}
+	args = append(args, req.Archive)
+
+	cmd := exec.CommandContext(ctx, sevenZipBinary(), args...)
+	cmd.Dir = req.Dir
+
+	return cmd
+}
+
+func (a sevenZipArchiver) Extract(ctx context.Context, req ExtractRequest) ([]byte, error) {
+	return runUnrar(a.command(ctx, req), req)
+}
+
+func (sevenZipArchiver) Test(ctx context.Context, path string, warnings []*regexp.Regexp) error {
+	return runTest(ctx, exec.CommandContext(ctx, sevenZipBinary(), []string{"t", path}...), path, warnings)
+}
@@ -0,0 +1,59 @@
+package rary
+
+import (
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CRCMismatch is the mismatch reported by SFVFile.VerifyCRC
+type CRCMismatch = ChecksumMismatch
+
+func (s *SFVFile) Files() []string {
+	return sortedKeys(s.items)
+}
+
+// VerifyCRC computes the CRC32 of every file listed in the SFV and returns the files whose checksum differs
+func (s *SFVFile) VerifyCRC(dir *DirSnapshot) ([]CRCMismatch, error) {
+	return verifyDigests(dir, s.items, func() hash.Hash { return crc32.NewIEEE() })
+}
+
+func (s *SFVFile) Verify(dir *DirSnapshot) ([]ChecksumMismatch, error) {
+	return s.VerifyCRC(dir)
+}
+
+// WriteSFV computes the CRC32 of every file in the snapshot and writes them to w as an SFV, with a comment header
+// noting when it was generated. Use the extension filters of the snapshot to limit which files are included.
+// Existing .sfv files are left out
+func WriteSFV(dir *DirSnapshot, w io.Writer) error {
+	files := []string{}
+	for _, entry := range dir.files {
+		if entry.dir || entry.rel == "" || strings.EqualFold(filepath.Ext(entry.rel), ".sfv") {
+			continue
+		}
+		files = append(files, entry.rel)
+	}
+	sort.Strings(files)
+
+	if _, err := fmt.Fprintf(w, "; Generated by rar-hunter on %s\n", time.Now().Format("2006-01-02 15:04:05")); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		crc, err := fileDigest(filepath.Join(dir.root, filepath.FromSlash(file)), crc32.NewIEEE())
+		if err != nil {
+			return fmt.Errorf("failed to compute crc for %s: %w", file, err)
+		}
+
+		if _, err := fmt.Fprintf(w, "%s %s\n", file, strings.ToUpper(crc)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
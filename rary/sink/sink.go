@@ -0,0 +1,122 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sendTimeout bounds how long delivering a single event may take, since events are sent on the goroutine that
+// published them
+const sendTimeout = 5 * time.Second
+
+// Line is how an event is encoded, one JSON object per line
+type Line struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Event any       `json:"event"`
+}
+
+// Sink forwards events as JSON lines to a unix domain socket or to an HTTP endpoint
+type Sink struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	url    string
+	client *http.Client
+	err    error
+}
+
+// Open returns a Sink for target, which is either an http or https URL that every event is POSTed to or the path of a
+// unix domain socket, optionally prefixed with unix:
+func Open(target string) (*Sink, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return &Sink{url: target, client: &http.Client{Timeout: sendTimeout}}, nil
+	}
+
+	conn, err := net.DialTimeout("unix", strings.TrimPrefix(target, "unix:"), sendTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sink{conn: conn}, nil
+}
+
+// Notify sends ev as a Line and can be used as the Notify option as is. Once sending fails the remaining events are
+// dropped and the error is returned by Close
+func (s *Sink) Notify(ev any) {
+	line, err := json.Marshal(Line{Type: reflect.TypeOf(ev).Name(), Time: time.Now(), Event: fields(ev)})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+
+	if s.conn != nil {
+		s.conn.SetWriteDeadline(time.Now().Add(sendTimeout))
+		_, s.err = s.conn.Write(line)
+		return
+	}
+	s.err = s.post(line)
+}
+
+func (s *Sink) post(line []byte) error {
+	resp, err := s.client.Post(s.url, "application/x-ndjson", bytes.NewReader(line))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded with %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// Close closes the socket and returns the error that stopped events from being sent, if any
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		if err := s.conn.Close(); err != nil && s.err == nil {
+			s.err = err
+		}
+	}
+	return s.err
+}
+
+// fields returns the exported fields of the event struct by name, with errors as their message since they would
+// otherwise encode as an empty object
+func fields(ev any) any {
+	v := reflect.ValueOf(ev)
+	if v.Kind() != reflect.Struct {
+		return ev
+	}
+
+	m := make(map[string]any, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		value := v.Field(i).Interface()
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		m[field.Name] = value
+	}
+
+	return m
+}
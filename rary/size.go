@@ -0,0 +1,77 @@
+package rary
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sizeUnits are the suffixes ParseSize accepts. KB, MB, GB and TB are decimal, the KiB forms are binary
+var sizeUnits = []struct {
+	suffix string
+	factor float64
+}{
+	{"KIB", 1 << 10},
+	{"MIB", 1 << 20},
+	{"GIB", 1 << 30},
+	{"TIB", 1 << 40},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"TB", 1e12},
+	{"K", 1e3},
+	{"M", 1e6},
+	{"G", 1e9},
+	{"T", 1e12},
+	{"B", 1},
+}
+
+// ParseSize parses a human readable size such as 100MB or 1.5GB into a number of bytes. A number without a unit is
+// taken as bytes
+func ParseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	factor := 1.0
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			factor = unit.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(n * factor), nil
+}
+
+// FormatBytes formats n bytes with a decimal unit, eg. 1.4 GB, so that ParseSize reads it back
+func FormatBytes(n int64) string {
+	if n < 1000 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value := float64(n)
+	unit := ""
+	for _, u := range []string{"KB", "MB", "GB", "TB"} {
+		value /= 1000
+		unit = u
+		if value < 1000 {
+			break
+		}
+	}
+
+	return fmt.Sprintf("%.1f %s", value, unit)
+}
+
+// formatDuration rounds d to a precision that reads well in a report, eg. 3m12s or 450ms
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,56 @@
+package rary
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// syncWriter serialises writes from the goroutines of DoAll to the same writer
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.w.Write(p)
+}
+
+// lineWriter writes every complete line written to it to w with a prefix, so that the output of extractions running
+// at the same time is not interleaved mid line
+type lineWriter struct {
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		if _, err := l.w.Write(append([]byte(l.prefix), l.buf[:i+1]...)); err != nil {
+			return len(p), err
+		}
+		l.buf = l.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush writes what is left of an unterminated last line
+func (l *lineWriter) Flush() error {
+	if len(l.buf) == 0 {
+		return nil
+	}
+
+	_, err := l.w.Write(append(append([]byte(l.prefix), l.buf...), '\n'))
+	l.buf = nil
+	return err
+}
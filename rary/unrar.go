@@ -0,0 +1,290 @@
+package rary
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnrarNotFound  = errors.New("unrar binary not found")
+	ErrCorruptArchive = errors.New("archive failed integrity test")
+	ErrMissingVolume  = errors.New("missing volume")
+	ErrUnrarFailed    = errors.New("unrar reported an error")
+)
+
+// unrarErrorClasses maps messages unrar writes to stderr onto the errors they are reported as. unrar does not always
+// report these through its exit code. When a pattern has a group, the matched text is included in the error
+var unrarErrorClasses = []struct {
+	pattern *regexp.Regexp
+	err     error
+}{
+	{regexp.MustCompile(`Cannot open (.+)`), ErrMissingVolume},
+	{regexp.MustCompile(`CRC failed`), ErrCorruptArchive},
+	{regexp.MustCompile(`checksum error`), ErrCorruptArchive},
+	{regexp.MustCompile(`Unexpected end of archive`), ErrCorruptArchive},
+	{regexp.MustCompile(`ERROR: (.+)`), ErrUnrarFailed},
+}
+
+// classifyStderr returns the error for the first recognised message in unrar's stderr
+func classifyStderr(stderr string) error {
+	for _, class := range unrarErrorClasses {
+		m := class.pattern.FindStringSubmatch(stderr)
+		if m == nil {
+			continue
+		}
+
+		if len(m) > 1 {
+			return fmt.Errorf("%w: %s", class.err, strings.TrimSpace(m[1]))
+		}
+		return class.err
+	}
+
+	return nil
+}
+
+// runUnrar runs cmd with stdout and stderr captured separately, and copied to the Stdout and Stderr of the request when set.
+// Stdout is scanned for progress as it is produced.
+// Lines of stderr matching the warnings of the request are passed to its Warn and do not fail the extraction, the
+// returned error includes whatever else unrar wrote to stderr
+func runUnrar(cmd *exec.Cmd, req ExtractRequest) ([]byte, error) {
+	cmd.WaitDelay = waitDelay
+	var stdoutBuf, stderrBuf bytes.Buffer
+	var stdout io.Writer = &stdoutBuf
+	cmd.Stderr = &stderrBuf
+	if req.Stdout != nil {
+		stdout = io.MultiWriter(&stdoutBuf, req.Stdout)
+	}
+	if req.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(&stderrBuf, req.Stderr)
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdout pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	scanErr := scanProgress(io.TeeReader(out, stdout), req.Archive, req.Notify)
+	if scanErr != nil {
+		io.Copy(stdout, out)
+	}
+
+	err = cmd.Wait()
+	stderr, warnings := splitWarnings(stderrBuf.String(), req.Warnings)
+	for _, warning := range warnings {
+		if req.Warn != nil {
+			req.Warn(warning)
+		}
+	}
+
+	// the archive tools exit with 1 when they only reported warnings
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(warnings) > 0 && stderr == "" {
+		err = nil
+	}
+	if err == nil && scanErr != nil {
+		err = fmt.Errorf("out read: %w", scanErr)
+	}
+	if classified := classifyStderr(stderr); classified != nil {
+		err = classified
+	}
+	if err != nil && stderr != "" {
+		err = fmt.Errorf("%w: %s", err, stderr)
+	}
+
+	return stdoutBuf.Bytes(), err
+}
+
+// splitWarnings separates the lines of stderr that match any of the warning patterns from the rest
+func splitWarnings(stderr string, patterns []*regexp.Regexp) (string, []string) {
+	if len(patterns) == 0 {
+		return strings.TrimSpace(stderr), nil
+	}
+
+	rest := []string{}
+	warnings := []string{}
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		warning := false
+		for _, pattern := range patterns {
+			if pattern.MatchString(line) {
+				warning = true
+				break
+			}
+		}
+		if warning {
+			warnings = append(warnings, line)
+		} else {
+			rest = append(rest, line)
+		}
+	}
+
+	return strings.Join(rest, "\n"), warnings
+}
+
+// unrarArchiver is the Archiver that runs the unrar binary
+type unrarArchiver struct{}
+
+// listTimeout bounds how long listing an archive may take, since unrar can hang on corrupt or password protected
+// archives
+const listTimeout = 30 * time.Second
+
+// waitDelay bounds how long the output of a killed tool is read for. When the binary is a wrapper script its child
+// can outlive it and keep stdout and stderr open
+const waitDelay = time.Second
+
+func (unrarArchiver) List(ctx context.Context, path string) ([]string, error) {
+	return listLines(ctx, func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, unrarBinary(), []string{"lb", path}...)
+	})
+}
+
+// Entries runs 'unrar l' and parses the table it prints. unrar 5 and later do not print the packed size or CRC in this
+// listing so those are left empty
+func (unrarArchiver) Entries(ctx context.Context, path string) ([]ArchiveEntry, error) {
+	lines, err := listLines(ctx, func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, unrarBinary(), []string{"l", path}...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseListing(lines)
+}
+
+// parseListing parses the table of 'unrar l'. The columns are found from the header above the first dashed
+// separator, since their order differs between unrar versions, and rows are read until the next separator so the
+// banner and totals are ignored
+func parseListing(lines []string) ([]ArchiveEntry, error) {
+	start := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "---") {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("no listing found in unrar output")
+	}
+
+	header := strings.Fields(lines[start-1])
+	nameFirst := len(header) > 0 && header[0] == "Name"
+	entries := []ArchiveEntry{}
+	for _, line := range lines[start+1:] {
+		if strings.HasPrefix(line, "---") {
+			break
+		}
+
+		columns, ok := splitRow(line, len(header), nameFirst)
+		if !ok {
+			continue
+		}
+
+		entry := ArchiveEntry{}
+		var date, clock string
+		for i, column := range columns {
+			switch header[i] {
+			case "Name":
+				entry.Name = column
+			case "Size":
+				entry.Size, _ = strconv.ParseInt(column, 10, 64)
+			case "Packed":
+				entry.Packed, _ = strconv.ParseInt(column, 10, 64)
+			case "Date":
+				date = column
+			case "Time":
+				clock = column
+			case "Checksum", "CRC":
+				entry.CRC = column
+			case "Attributes", "Attr":
+				entry.Attributes = column
+				entry.Dir = strings.HasPrefix(column, "d") || strings.Contains(column, "D")
+			}
+		}
+		entry.ModTime = parseListingTime(date, clock)
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// listingTimeLayouts are the date formats of unrar 5 and later, and of unrar 4 which prints DD-MM-YY
+var listingTimeLayouts = []string{"2006-01-02 15:04", "02-01-06 15:04"}
+
+func parseListingTime(date, clock string) time.Time {
+	for _, layout := range listingTimeLayouts {
+		if t, err := time.ParseInLocation(layout, date+" "+clock, time.Local); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// splitRow splits a row of the listing into n columns in the order of the header. The name may contain spaces so it
+// is whatever is left over at the start of the row when nameFirst is set, or at the end otherwise
+func splitRow(line string, n int, nameFirst bool) ([]string, bool) {
+	fields := strings.Fields(line)
+	if n < 2 || len(fields) < n {
+		return nil, false
+	}
+
+	if nameFirst {
+		columns := fields[len(fields)-(n-1):]
+		rest := strings.TrimSpace(line)
+		for i := len(columns) - 1; i >= 0; i-- {
+			rest = strings.TrimSpace(strings.TrimSuffix(rest, columns[i]))
+		}
+		return append([]string{rest}, columns...), true
+	}
+
+	columns := append([]string{}, fields[:n-1]...)
+	rest := strings.TrimSpace(line)
+	for _, column := range columns {
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, column))
+	}
+
+	return append(columns, rest), true
+}
+
+func (unrarArchiver) command(ctx context.Context, req ExtractRequest) *exec.Cmd {
+	args := []string{req.Mode.command(), req.Overwrite.flag(), req.Archive}
+	if req.Dest != "" {
+		// unrar only treats the last argument as the destination when it ends with a path separator
+		args = append(args, req.Dest+string(os.PathSeparator))
+	}
+
+	cmd := exec.CommandContext(ctx, unrarBinary(), args...)
+	cmd.Dir = req.Dir
+
+	return cmd
+}
+
+func (a unrarArchiver) Extract(ctx context.Context, req ExtractRequest) ([]byte, error) {
+	return runUnrar(a.command(ctx, req), req)
+}
+
+// Test runs the integrity test of the tool of the archive, 'unrar t' for rar. A failing test is reported as
+// ErrCorruptArchive and a missing binary as ErrUnrarNotFound
+func Test(u *Unrar) error {
+	return archiverFor(u.filename).Test(context.Background(), u.Path(), nil)
+}
+
+func (unrarArchiver) Test(ctx context.Context, path string, warnings []*regexp.Regexp) error {
+	return runTest(ctx, exec.CommandContext(ctx, unrarBinary(), []string{"t", path}...), path, warnings)
+}
@@ -0,0 +1,313 @@
+package rary
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUnrarCommandOverwrite(t *testing.T) {
+	tests := []struct {
+		name string
+		req  ExtractRequest
+		args []string
+	}{
+		{
+			name: "skip by default",
+			req:  ExtractRequest{Archive: "a.rar", Dir: "/set"},
+			args: []string{"unrar", "e", "-o-", "a.rar"},
+		},
+		{
+			name: "overwrite",
+			req:  ExtractRequest{Archive: "a.rar", Dir: "/set", Overwrite: OverwriteAll},
+			args: []string{"unrar", "e", "-o+", "a.rar"},
+		},
+		{
+			name: "rename",
+			req:  ExtractRequest{Archive: "a.rar", Dir: "/set", Overwrite: OverwriteRename},
+			args: []string{"unrar", "e", "-or", "a.rar"},
+		},
+		{
+			name: "full paths into a destination",
+			req:  ExtractRequest{Archive: "a.rar", Dir: "/set", Dest: "/out/set", Mode: ExtractFullPath, Overwrite: OverwriteAll},
+			args: []string{"unrar", "x", "-o+", "a.rar", "/out/set" + string(os.PathSeparator)},
+		},
+	}
+
+	previous := config
+	Configure(Config{})
+	t.Cleanup(func() { Configure(previous) })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := unrarArchiver{}.command(context.Background(), tt.req)
+			if !reflect.DeepEqual(cmd.Args, tt.args) {
+				t.Errorf("args = %v, want %v", cmd.Args, tt.args)
+			}
+			if cmd.Dir != tt.req.Dir {
+				t.Errorf("dir = %q, want %q", cmd.Dir, tt.req.Dir)
+			}
+		})
+	}
+}
+
+func TestTestBeforeExtractWarnings(t *testing.T) {
+	unrar := writeScript(t, t.TempDir(), "unrar", `case "$1" in
+t) echo "The file header is corrupt" >&2; exit 1;;
+esac
+echo "All OK"
+`)
+	previous := config
+	Configure(Config{UnrarBinary: unrar})
+	t.Cleanup(func() { Configure(previous) })
+
+	tests := []struct {
+		name     string
+		patterns []string
+		corrupt  bool
+	}{
+		{name: "matching warning passes the test", patterns: []string{`file header is corrupt`}},
+		{name: "other messages fail the test", patterns: []string{`recovery record`}, corrupt: true},
+		{name: "no patterns fail the test", corrupt: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wd := t.TempDir()
+			quarantine := t.TempDir()
+			writeFiles(t, wd, "a.rar")
+			target := &Unrar{filename: "a.rar", wd: wd, volumes: []string{"a.rar"}}
+
+			results, err := DoAll(context.Background(), []*Unrar{target}, io.Discard, DoAllOptions{
+				TestBeforeExtract: true,
+				WarningPatterns:   tt.patterns,
+				QuarantineDir:     quarantine,
+			})
+			if corrupt := errors.Is(results[0].Err, ErrCorruptArchive); corrupt != tt.corrupt {
+				t.Fatalf("DoAll = %v, want corrupt %t", err, tt.corrupt)
+			}
+
+			_, statErr := os.Stat(filepath.Join(wd, "a.rar"))
+			if quarantined := statErr != nil; quarantined != tt.corrupt {
+				t.Errorf("a.rar quarantined = %t, want %t", quarantined, tt.corrupt)
+			}
+		})
+	}
+}
+
+func TestTestClassifiesStderr(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		err    error
+	}{
+		{name: "missing volume", stderr: "Cannot open a.r01", err: ErrMissingVolume},
+		{name: "crc error", stderr: "a.rar - CRC failed", err: ErrCorruptArchive},
+		{name: "fatal error", stderr: "ERROR: Unknown format", err: ErrUnrarFailed},
+		{name: "unrecognised message", stderr: "something went wrong", err: ErrCorruptArchive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unrar := writeScript(t, t.TempDir(), "unrar", "echo '"+tt.stderr+"' >&2; exit 3\n")
+			previous := config
+			Configure(Config{UnrarBinary: unrar})
+			t.Cleanup(func() { Configure(previous) })
+
+			err := unrarArchiver{}.Test(context.Background(), "a.rar", nil)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Test = %v, want %v", err, tt.err)
+			}
+			if tt.err != ErrCorruptArchive && errors.Is(err, ErrCorruptArchive) {
+				t.Errorf("Test = %v, a %v is not corrupt", err, tt.err)
+			}
+		})
+	}
+}
+
+// unrar5Listing is what 'unrar l' prints for unrar 5 and later. Only the lines listLines keeps are included
+var unrar5Listing = []string{
+	"UNRAR 6.24 freeware      Copyright (c) 1993-2023 Alexander Roshal",
+	"Archive: movie.part01.rar",
+	"Details: RAR 5, volume",
+	"Attributes      Size     Date    Time   Name",
+	"----------- ---------  ---------- -----  ----",
+	"-rw-r--r--  734003200  2023-04-01 12:30  My Movie 2023.mkv",
+	"..A....          512  2023-04-01 12:31  my movie.nfo",
+	"drwxr-xr-x          0  2023-04-01 12:30  Subs",
+	"...D...            0  2023-04-01 12:30  Extras/Behind the Scenes",
+	"----------- ---------  ---------- -----  ----",
+	"734003712                    4  volume 1",
+}
+
+// unrar4Listing is what 'unrar l' prints for unrar 4, which has the name first and prints DD-MM-YY dates
+var unrar4Listing = []string{
+	"UNRAR 4.20 freeware      Copyright (c) 1993-2012 Alexander Roshal",
+	"Archive movie.rar",
+	"Name             Size   Packed Ratio  Date   Time     Attr      CRC   Meth Ver",
+	"-------------------------------------------------------------------------------",
+	"My Movie 2023.mkv 734003200 733001234  99% 01-04-23 12:30  .....A.   8ABCDEF1 m3b 2.9",
+	"movie.nfo              512      300  58% 01-04-23 12:31  .....A.   0BADC0DE m3b 2.9",
+	"Subs                     0        0   0% 01-04-23 12:30  .D.....   00000000 m0  2.0",
+	"-------------------------------------------------------------------------------",
+	"3        734003712 733001534  99%",
+}
+
+func TestParseListing(t *testing.T) {
+	at := func(clock string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", "2023-04-01 "+clock, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name  string
+		lines []string
+		want  []ArchiveEntry
+	}{
+		{
+			name:  "unrar 5",
+			lines: unrar5Listing,
+			want: []ArchiveEntry{
+				{Name: "My Movie 2023.mkv", Size: 734003200, ModTime: at("12:30"), Attributes: "-rw-r--r--"},
+				{Name: "my movie.nfo", Size: 512, ModTime: at("12:31"), Attributes: "..A...."},
+				{Name: "Subs", ModTime: at("12:30"), Attributes: "drwxr-xr-x", Dir: true},
+				{Name: "Extras/Behind the Scenes", ModTime: at("12:30"), Attributes: "...D...", Dir: true},
+			},
+		},
+		{
+			name:  "unrar 4",
+			lines: unrar4Listing,
+			want: []ArchiveEntry{
+				{Name: "My Movie 2023.mkv", Size: 734003200, Packed: 733001234, ModTime: at("12:30"), CRC: "8ABCDEF1", Attributes: ".....A."},
+				{Name: "movie.nfo", Size: 512, Packed: 300, ModTime: at("12:31"), CRC: "0BADC0DE", Attributes: ".....A."},
+				{Name: "Subs", ModTime: at("12:30"), CRC: "00000000", Attributes: ".D.....", Dir: true},
+			},
+		},
+		{
+			name:  "empty archive",
+			lines: []string{"Archive: empty.rar", "Attributes      Size     Date    Time   Name", "----------- ---------  ---------- -----  ----", "----------- ---------  ---------- -----  ----", "0                    0"},
+			want:  []ArchiveEntry{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseListing(tt.lines)
+			if err != nil {
+				t.Fatalf("parseListing: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseListing =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseListingWithoutTable(t *testing.T) {
+	lines := []string{"UNRAR 6.24 freeware      Copyright (c) 1993-2023 Alexander Roshal", "movie.rar is not RAR archive"}
+	if entries, err := parseListing(lines); err == nil {
+		t.Errorf("parseListing = %v, want an error", entries)
+	}
+}
+
+func TestSplitRow(t *testing.T) {
+	tests := []struct {
+		line      string
+		n         int
+		nameFirst bool
+		want      []string
+	}{
+		{"-rw-r--r--  10  2023-04-01 12:30  a b  c.mkv", 5, false, []string{"-rw-r--r--", "10", "2023-04-01", "12:30", "a b  c.mkv"}},
+		{"a b.mkv 10 5 50% 01-04-23 12:30 .....A. 0BADC0DE m3 2.9", 10, true, []string{"a b.mkv", "10", "5", "50%", "01-04-23", "12:30", ".....A.", "0BADC0DE", "m3", "2.9"}},
+		{"2.9 10 5 50% 01-04-23 12:30 .....A. 0BADC0DE m3 2.9", 10, true, []string{"2.9", "10", "5", "50%", "01-04-23", "12:30", ".....A.", "0BADC0DE", "m3", "2.9"}},
+		// the totals row has fewer columns than the header
+		{"734003712    4", 5, false, nil},
+	}
+
+	for _, tt := range tests {
+		got, ok := splitRow(tt.line, tt.n, tt.nameFirst)
+		if ok != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitRow(%q) = %q, %t, want %q", tt.line, got, ok, tt.want)
+		}
+	}
+}
+
+func TestDoAllOverwriteExisting(t *testing.T) {
+	// the fake behaves like unrar when movie.mkv is already there: -o- skips it, -o+ replaces it and -or extracts it
+	// as movie(1).mkv
+	unrar := writeScript(t, t.TempDir(), "unrar", `out=movie.mkv
+if [ -e movie.mkv ]; then
+	case "$2" in
+	-o-) echo "Skipping    movie.mkv"; echo "All OK"; exit 0;;
+	-or) out="movie(1).mkv";;
+	esac
+fi
+echo extracted > "$out"
+echo "Extracting  $out  OK"
+echo "All OK"
+`)
+	previous := config
+	Configure(Config{UnrarBinary: unrar})
+	t.Cleanup(func() { Configure(previous) })
+
+	tests := []struct {
+		name   string
+		policy OverwritePolicy
+		// want are the contents of the files in the directory once the set was extracted
+		want map[string]string
+	}{
+		{
+			name:   "skip",
+			policy: OverwriteSkip,
+			want:   map[string]string{"a.rar": "a.rar", "movie.mkv": "existing\n"},
+		},
+		{
+			name:   "overwrite",
+			policy: OverwriteAll,
+			want:   map[string]string{"a.rar": "a.rar", "movie.mkv": "extracted\n"},
+		},
+		{
+			name:   "rename",
+			policy: OverwriteRename,
+			want:   map[string]string{"a.rar": "a.rar", "movie.mkv": "existing\n", "movie(1).mkv": "extracted\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wd := t.TempDir()
+			writeFiles(t, wd, "a.rar")
+			if err := os.WriteFile(filepath.Join(wd, "movie.mkv"), []byte("existing\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			target := &Unrar{filename: "a.rar", wd: wd, volumes: []string{"a.rar"}}
+			if _, err := DoAll(context.Background(), []*Unrar{target}, io.Discard, DoAllOptions{OverwritePolicy: tt.policy}); err != nil {
+				t.Fatalf("DoAll: %v", err)
+			}
+
+			entries, err := os.ReadDir(wd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, entry := range entries {
+				data, err := os.ReadFile(filepath.Join(wd, entry.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[entry.Name()] = string(data)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
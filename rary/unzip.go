@@ -0,0 +1,85 @@
+package rary
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// unzipArchiver is the Archiver that runs unzip for the zip format. unzip cannot extract a 'name.z01', 'name.z02',
+// 'name.zip' split set so those are passed on to 7z
+type unzipArchiver struct{}
+
+// splitZip reports whether the zip at path is the last volume of a split set
+func splitZip(path string) bool {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range []string{".z01", ".Z01"} {
+		if _, err := os.Stat(base + ext); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (unzipArchiver) List(ctx context.Context, path string) ([]string, error) {
+	if splitZip(path) {
+		return sevenZipArchiver{}.List(ctx, path)
+	}
+
+	lines, err := listLines(ctx, func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, unzipBinary(), []string{"-Z1", path}...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, line := range lines {
+		if !strings.HasSuffix(line, "/") {
+			names = append(names, line)
+		}
+	}
+
+	return names, nil
+}
+
+// command builds the unzip invocation. unzip cannot rename existing files so OverwriteRename keeps them like
+// OverwriteSkip does
+func (unzipArchiver) command(ctx context.Context, req ExtractRequest) *exec.Cmd {
+	if splitZip(filepath.Join(req.Dir, req.Archive)) {
+		return sevenZipArchiver{}.command(ctx, req)
+	}
+
+	args := []string{"-n"}
+	if req.Overwrite == OverwriteAll {
+		args = []string{"-o"}
+	}
+	if req.Mode == ExtractFlat {
+		args = append(args, "-j")
+	}
+	args = append(args, req.Archive)
+	if req.Dest != "" {
+		args = append(args, "-d", req.Dest)
+	}
+
+	cmd := exec.CommandContext(ctx, unzipBinary(), args...)
+	cmd.Dir = req.Dir
+
+	return cmd
+}
+
+func (a unzipArchiver) Extract(ctx context.Context, req ExtractRequest) ([]byte, error) {
+	return runUnrar(a.command(ctx, req), req)
+}
+
+func (unzipArchiver) Test(ctx context.Context, path string, warnings []*regexp.Regexp) error {
+	if splitZip(path) {
+		return sevenZipArchiver{}.Test(ctx, path, warnings)
+	}
+
+	return runTest(ctx, exec.CommandContext(ctx, unzipBinary(), []string{"-tq", path}...), path, warnings)
+}
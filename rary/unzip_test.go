@@ -0,0 +1,47 @@
+package rary
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestUnzipCommandSplitSet(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		args  []string
+	}{
+		{
+			name:  "single zip",
+			files: []string{"a.zip"},
+			args:  []string{"unzip-bin", "-n", "-j", "a.zip"},
+		},
+		{
+			name:  "split set",
+			files: []string{"a.z01", "a.z02", "a.zip"},
+			args:  []string{"7z-bin", "e", "-y", "-aos", "a.zip"},
+		},
+		{
+			name:  "split set with uppercase volumes",
+			files: []string{"A.Z01", "A.zip"},
+			args:  []string{"7z-bin", "e", "-y", "-aos", "A.zip"},
+		},
+	}
+
+	previous := config
+	Configure(Config{UnzipBinary: "unzip-bin", SevenZipBinary: "7z-bin"})
+	t.Cleanup(func() { Configure(previous) })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files...)
+
+			req := ExtractRequest{Archive: tt.files[len(tt.files)-1], Dir: dir}
+			cmd := unzipArchiver{}.command(context.Background(), req)
+			if !reflect.DeepEqual(cmd.Args, tt.args) {
+				t.Errorf("args = %v, want %v", cmd.Args, tt.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package rary
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/burmudar/rar-hunter/rary/event"
+)
+
+var partVolumeRe = regexp.MustCompile(`(?i)\.part(\d+)\.rar$`)
+var oldVolumeRe = regexp.MustCompile(`(?i)\.r\d{2,3}$`)
+var anyVolumeRe = regexp.MustCompile(`(?i)\.(rar|r\d{2,3})$`)
+
+// metadataExts are files that ship alongside a release, or the lock file of a set being extracted, and are not the
+// extracted payload
+var metadataExts = []string{".nfo", ".txt", ".diz", ".jpg", ".jpeg", ".png", ".url", ".sfv", ".md5", ".sha1", ".sha256", ".lock"}
+
+// downloadExts are the files download clients write while a file is still being downloaded, eg. 'a.rar.part'
+var downloadExts = []string{".part", ".partial", ".crdownload", ".!qb", ".!ut", ".tmp"}
+
+var ErrAlreadyExtracted = errors.New("already extracted")
+
+// extracted reports whether the archives of the directory are gone but the payload was left behind, which is what a
+// set looks like once it has been extracted and its volumes deleted. When the checksum file lists the payload one of
+// those files has to be there, otherwise anything other than release metadata and unfinished downloads is taken as
+// the payload
+func extracted(dir *DirSnapshot, sfv ChecksumFile) bool {
+	if len(dir.Find(isVolume)) > 0 {
+		return false
+	}
+
+	_, listed := splitChecksumFiles(sfv)
+	payload := []string{}
+	for _, f := range listed {
+		if !hasExt(metadataExts, f) {
+			payload = append(payload, f)
+		}
+	}
+	if len(payload) > 0 {
+		for _, f := range payload {
+			if dir.Has(f) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, entry := range dir.files {
+		if entry.dir || strings.Contains(entry.rel, "/") {
+			continue
+		}
+		if !hasExt(metadataExts, entry.rel) && !hasExt(downloadExts, entry.rel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PrimaryVolume returns the volume unrar should be invoked on. For the 'name.partNN.rar' scheme this is the first
+// part, for the 'name.rar', 'name.r00', 'name.r01' scheme it is the plain .rar
+func PrimaryVolume(dir *DirSnapshot) (string, error) {
+	rars := dir.findInRoot(func(item string) bool { return hasExt([]string{".rar"}, item) })
+	sort.Strings(rars)
+
+	parts := []string{}
+	for _, rar := range rars {
+		m := partVolumeRe.FindStringSubmatch(rar)
+		if m == nil {
+			return rar, nil
+		}
+
+		if n, err := strconv.Atoi(m[1]); err == nil && n == 1 {
+			return rar, nil
+		}
+		parts = append(parts, rar)
+	}
+
+	if len(parts) > 0 {
+		return "", fmt.Errorf("first volume missing from multi-part set in %s: found %v", dir.root, parts)
+	}
+
+	if old := dir.findInRoot(oldVolumeRe.MatchString); len(old) > 0 {
+		return "", fmt.Errorf("found %d .rNN volumes but no .rar in %s", len(old), dir.root)
+	}
+
+	return "", fmt.Errorf("no .rar found in %s", dir.root)
+}
+
+// volumeMatcher returns a regexp matching the names of every volume in the same set as primary
+func volumeMatcher(primary string) *regexp.Regexp {
+	return formatOf(primary).volumes(primary)
+}
+
+func rarVolumeMatcher(primary string) *regexp.Regexp {
+	if m := partVolumeRe.FindStringIndex(primary); m != nil {
+		base := regexp.QuoteMeta(primary[:m[0]])
+		return regexp.MustCompile(`(?i)^` + base + `\.part\d+\.rar$`)
+	}
+
+	base := regexp.QuoteMeta(strings.TrimSuffix(primary, filepath.Ext(primary)))
+	return regexp.MustCompile(`(?i)^` + base + `\.(rar|r\d{2,3})$`)
+}
+
+// volumesOf returns every volume that belongs to the same set as primary
+func volumesOf(dir *DirSnapshot, primary string) []string {
+	volumes := dir.findInRoot(volumeMatcher(primary).MatchString)
+	sort.Strings(volumes)
+
+	return volumes
+}
+
+// Volumes returns every file that makes up the set: the volumes found in the directory, any volumes of the set
+// listed in the checksum file, and the checksum files themselves
+func (u *Unrar) Volumes(dir *DirSnapshot, sfv ChecksumFile) []string {
+	files := volumesOf(dir, u.filename)
+	seen := make(map[string]bool)
+	for _, f := range files {
+		seen[f] = true
+	}
+
+	// the names are those on disk, which a case insensitive snapshot matches to checksum entries of another case
+	matcher := volumeMatcher(u.filename)
+	for _, f := range sfv.Files() {
+		name, ok := dir.resolve(f)
+		if ok && !seen[name] && matcher.MatchString(name) {
+			seen[name] = true
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+
+	files = append(files, u.checksums...)
+
+	return files
+}
+
+// deleteVolumes removes the volumes and checksum file of the set. Only files that were identified as part of the
+// set are removed
+func deleteVolumes(u *Unrar, notify func(ev any)) error {
+	files := u.volumes
+
+	deleted := []string{}
+	var errs []error
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(u.wd, file)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted = append(deleted, file)
+	}
+
+	if notify != nil && len(deleted) > 0 {
+		notify(event.VolumesDeleted{Archive: u.filename, WorkDir: u.wd, Files: deleted})
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to delete %d of %d files: %v", len(errs), len(files), errs)
+	}
+
+	return nil
+}
+
+// quarantine moves the volumes and checksum file of a corrupt set into a subdirectory of dir named after the
+// directory of the set. Files that were partially extracted are left where they were written
+func quarantine(u *Unrar, dir string, notify func(ev any)) error {
+	dest := filepath.Join(dir, filepath.Base(u.wd))
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("failed to create quarantine dir: %w", err)
+	}
+
+	moved := []string{}
+	var errs []error
+	for _, file := range u.volumes {
+		target := filepath.Join(dest, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.Rename(filepath.Join(u.wd, file), target); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		moved = append(moved, file)
+	}
+
+	if notify != nil && len(moved) > 0 {
+		notify(event.Quarantined{Archive: u.filename, WorkDir: u.wd, Dir: dest, Files: moved})
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to quarantine %d of %d files: %v", len(errs), len(u.volumes), errs)
+	}
+
+	return nil
+}